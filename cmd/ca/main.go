@@ -0,0 +1,36 @@
+package main
+
+import (
+	"asyncProxy/config"
+	"asyncProxy/proxy/common"
+	"flag"
+	"log"
+	"os"
+)
+
+// 导出服务端使用的根证书, 如 go run ./cmd/ca -format der -out ca.crt
+func main() {
+	configPath := flag.String("config", "./app/config.yml", "配置文件路径")
+	format := flag.String("format", common.CAFormatPem, "导出格式: pem、der、mobileconfig")
+	out := flag.String("out", "", "输出文件, 为空时输出到标准输出")
+	flag.Parse()
+
+	conf := config.NewConfig(*configPath)
+	ca, e := common.LoadOrCreateCA(conf.Server.Ca.Cert, conf.Server.Ca.Key, conf.Server.Ca.CacheSize)
+	if e != nil {
+		log.Fatalln("加载根证书失败:", e)
+	}
+	file, e := ca.Export(*format)
+	if e != nil {
+		log.Fatalln("导出根证书失败:", e)
+	}
+	if *out == "" {
+		_, e = os.Stdout.Write(file.Content)
+	} else {
+		e = os.WriteFile(*out, file.Content, 0o644)
+	}
+	if e != nil {
+		log.Fatalln("写入根证书失败:", e)
+	}
+	log.Println("SHA-256指纹:", ca.Fingerprint())
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"asyncProxy/config"
+	"asyncProxy/constant"
 	"asyncProxy/ws/client"
+	"asyncProxy/ws/transport"
 	"fmt"
 	"github.com/lxzan/gws"
 	"log"
+	"strconv"
 	"time"
 )
 
@@ -25,12 +28,16 @@ func main() {
 		}
 
 		wsConnect, response, e := gws.NewClient(&handler, &gws.ClientOption{
-			ReadAsyncEnabled: true,
+			// 同一个流的消息帧必须按顺序处理, 不能开启并行读
+			ReadAsyncEnabled: false,
 			CompressEnabled:  true,
 			Recovery:         gws.Recovery,
 			Addr:             addr,
 			RequestHeader: map[string][]string{
-				"Authorization": {conf.Client.ServerAuthorization},
+				"Authorization":                {conf.Client.ServerAuthorization},
+				constant.HeaderEdgeWeight:      {strconv.Itoa(conf.Client.Weight)},
+				constant.HeaderEdgeLabels:      {transport.FormatLabels(conf.Client.Labels)},
+				constant.HeaderEdgeMaxInFlight: {strconv.Itoa(conf.Client.MaxInFlight)},
 			},
 		})
 		if e != nil {
@@ -45,10 +52,6 @@ func main() {
 			wsConnect.ReadLoop()
 			log.Println("read loop is terminated")
 		}()
-		e = wsConnect.WritePing([]byte(time.Now().Format(time.RFC822Z)))
-		if e != nil {
-			log.Println("ping error:", e)
-		}
 		<-onCloseSignal
 		log.Println("connection lost!")
 		log.Println("will retry in 10 secs")
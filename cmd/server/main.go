@@ -2,18 +2,144 @@ package main
 
 import (
 	"asyncProxy/config"
+	"asyncProxy/job"
+	"asyncProxy/proxy/common"
 	"asyncProxy/proxy/httpProxy"
 	"asyncProxy/proxy/socks5Proxy"
+	"asyncProxy/scheduler"
 	"asyncProxy/web"
 	"asyncProxy/ws"
+	"asyncProxy/ws/edge"
+	"log"
+	"time"
 )
 
 func main() {
 	conf := config.NewConfig("./app/config.yml")
-	p := httpProxy.NewProxy(conf.Server.HttpHost, conf.Server.HttpPort)
+	dispatch := conf.Server.Dispatch
+	selector, e := edge.NewSelector(dispatch.Selector)
+	if e != nil {
+		log.Fatalln("节点选择策略配置错误:", e)
+	}
+	ws.EdgeSet.SetSelector(selector)
+	if breaker := dispatch.CircuitBreaker; breaker.Enabled {
+		policy := edge.DefaultHealthPolicy()
+		policy.MaxFailureRate = breaker.MaxFailureRate
+		policy.MaxTimeoutRate = breaker.MaxTimeoutRate
+		policy.MaxLatency = time.Duration(breaker.MaxLatency) * time.Millisecond
+		if breaker.Window > 0 {
+			policy.Window = breaker.Window
+		}
+		if breaker.MinRequests > 0 {
+			policy.MinRequests = breaker.MinRequests
+		}
+		if breaker.OpenDuration > 0 {
+			policy.OpenDuration = time.Duration(breaker.OpenDuration) * time.Second
+		}
+		if breaker.ProbeRequests > 0 {
+			policy.ProbeRequests = breaker.ProbeRequests
+		}
+		ws.EdgeSet.SetHealthPolicy(policy)
+	}
+	if hedge := dispatch.Hedge; hedge.Enabled {
+		ws.EdgeSet.SetHedgePolicy(&edge.HedgePolicy{
+			Delay:      time.Duration(hedge.Delay) * time.Millisecond,
+			Percentile: hedge.Percentile,
+			Methods:    hedge.Methods,
+		})
+	}
+	ws.EdgeSet.SetQueue(dispatch.Queue.Size, time.Duration(dispatch.Queue.Timeout)*time.Second)
+	ws.EdgeSet.SetEdgeGracePeriod(time.Duration(dispatch.Queue.WaitForEdge)*time.Second, dispatch.Queue.WaitForEdgeSize)
+	ws.EdgeSet.SetPriorityAging(time.Duration(dispatch.Queue.PriorityAging) * time.Second)
+	ws.EdgeSet.SetAffinityTTL(time.Duration(dispatch.Affinity.Ttl) * time.Second)
+	affinity, e := common.ParseAffinitySource(dispatch.Affinity.Source)
+	if e != nil {
+		log.Fatalln("会话保持配置错误:", e)
+	}
+	retry := &edge.RetryPolicy{
+		MaxAttempts:    dispatch.Retry.MaxAttempts,
+		On:             dispatch.Retry.On,
+		StatusCodes:    dispatch.Retry.StatusCodes,
+		Methods:        dispatch.Retry.Methods,
+		AttemptTimeout: time.Duration(dispatch.Retry.AttemptTimeout) * time.Second,
+	}
+	if e := retry.Validate(); e != nil {
+		log.Fatalln("重试策略配置错误:", e)
+	}
+	ca, e := common.LoadOrCreateCA(conf.Server.Ca.Cert, conf.Server.Ca.Key, conf.Server.Ca.CacheSize)
+	if e != nil {
+		log.Fatalln("加载根证书失败:", e)
+	}
+	users, e := common.NewUserStore(conf.Server.Auth.Users, conf.Server.Auth.File)
+	if e != nil {
+		log.Fatalln("加载代理用户失败:", e)
+	}
+	idleTimeout := time.Duration(conf.Server.ProxyIdleTimeout) * time.Second
+	requestTimeout := time.Duration(conf.Server.ProxyRequestTimeout) * time.Second
+	tunnel := conf.Server.Tunnel
+	httpTunnel, e := common.NewTunnelPolicy(tunnel.HttpProxy, tunnel.Hosts, tunnel.Bypass)
+	if e != nil {
+		log.Fatalln("隧道配置错误:", e)
+	}
+	socks5Tunnel, e := common.NewTunnelPolicy(tunnel.Socks5Proxy, tunnel.Hosts, tunnel.Bypass)
+	if e != nil {
+		log.Fatalln("隧道配置错误:", e)
+	}
+	p := httpProxy.NewProxy(conf.Server.HttpHost, conf.Server.HttpPort, &common.ProxyOptions{
+		Tunnel:         httpTunnel,
+		Affinity:       affinity,
+		Retry:          retry,
+		CA:             ca,
+		IdleTimeout:    idleTimeout,
+		RequestTimeout: requestTimeout,
+		Users:          users,
+	})
 	go p.Listen()
-	s := socks5Proxy.NewProxy(conf.Server.Socks5Host, conf.Server.Socks5Port)
+	s := socks5Proxy.NewProxy(conf.Server.Socks5Host, conf.Server.Socks5Port, &common.ProxyOptions{
+		Tunnel:         socks5Tunnel,
+		Affinity:       affinity,
+		Retry:          retry,
+		UdpIdleTimeout: time.Duration(conf.Server.Socks5UdpIdleTimeout) * time.Second,
+		CA:             ca,
+		IdleTimeout:    idleTimeout,
+		RequestTimeout: requestTimeout,
+		Users:          users,
+	})
 	go s.ListenAndServe()
-	go web.Start(conf.Server.WebHost, conf.Server.WebPort, conf.Server.WebUsername, conf.Server.WebPassword)
+	store, e := job.NewJobStore(conf.Server.Jobs.Store.Type, conf.Server.Jobs.Store.Path)
+	if e != nil {
+		log.Fatalln("任务存储配置错误:", e)
+	}
+	jobs := job.NewManager(ws.EdgeSet, store, time.Duration(conf.Server.Jobs.Ttl)*time.Second, int64(conf.Server.Jobs.MaxBodySize)*1024)
+	webhook := conf.Server.Jobs.Webhook
+	jobs.SetWebhook(webhook.MaxAttempts, time.Duration(webhook.Backoff)*time.Second, time.Duration(webhook.Timeout)*time.Second)
+	batch := conf.Server.Jobs.Batch
+	jobs.SetBatch(batch.MaxRequests, batch.Parallelism, batch.MaxParallelism, int64(batch.MaxTotalBodySize)*1024,
+		time.Duration(batch.Timeout)*time.Second)
+	if e := jobs.Restore(); e != nil {
+		log.Fatalln("恢复任务失败:", e)
+	}
+	schedules := scheduler.NewScheduler(jobs)
+	for _, s := range conf.Server.Schedules {
+		_, e := schedules.Add(scheduler.Definition{
+			Name:     s.Name,
+			Cron:     s.Cron,
+			Interval: s.Interval,
+			Request: job.Request{
+				Method:   s.Method,
+				Url:      s.Url,
+				Headers:  s.Headers,
+				Body:     s.Body,
+				Timeout:  s.Timeout,
+				Labels:   s.Labels,
+				Priority: s.Priority,
+			},
+		})
+		if e != nil {
+			log.Fatalln("定时任务配置错误:", s.Name, e)
+		}
+	}
+	schedules.Start()
+	go web.Start(conf.Server.WebHost, conf.Server.WebPort, conf.Server.WebUsername, conf.Server.WebPassword, jobs, schedules, ca)
 	ws.Start(conf.Server.WsServerHost, conf.Server.WsServerPort, conf.Server.WsServerAuthorization)
 }
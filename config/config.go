@@ -15,6 +15,12 @@ type Config struct {
 		// ws代理服务端口
 		Socks5Host string `yaml:"socks5_host"`
 		Socks5Port uint16 `yaml:"socks5_port"`
+		// UDP关联空闲超时时间(秒)
+		Socks5UdpIdleTimeout int `yaml:"socks5_udp_idle_timeout"`
+		// 代理连接等待下一个请求的时间(秒)
+		ProxyIdleTimeout int `yaml:"proxy_idle_timeout"`
+		// 单个请求等待响应头的时间上限, 也是响应体没有进展的时间上限(秒), 隧道不受限制
+		ProxyRequestTimeout int `yaml:"proxy_request_timeout"`
 		// websocket和client通讯端口
 		WsServerHost          string `yaml:"ws_server_host"`
 		WsServerPort          uint16 `yaml:"ws_server_port"`
@@ -24,6 +30,148 @@ type Config struct {
 		WebPort     uint16 `yaml:"web_port"`
 		WebUsername string `yaml:"web_username"`
 		WebPassword string `yaml:"web_password"`
+		// 请求分发
+		Dispatch struct {
+			// 节点选择策略: round_robin、random、least_in_flight、weighted、lowest_rtt
+			Selector string `yaml:"selector"`
+			// 会话保持, 同一会话的请求固定走同一个节点
+			Affinity struct {
+				// 会话来源: none、client_ip、header、username
+				Source string `yaml:"source"`
+				// 固定关系的有效期(秒), 每次命中会续期
+				Ttl int `yaml:"ttl"`
+			} `yaml:"affinity"`
+			// 请求失败后换节点重试
+			Retry struct {
+				// 最多尝试次数, 1为不重试
+				MaxAttempts int `yaml:"max_attempts"`
+				// 可重试的失败: edge_error、timeout、disconnect
+				On []string `yaml:"on"`
+				// 目标返回这些状态码时也重试
+				StatusCodes []int `yaml:"status_codes"`
+				// 可重试的请求方法, 为空时只重试幂等方法
+				Methods []string `yaml:"methods"`
+				// 单次尝试的超时时间(秒)
+				AttemptTimeout int `yaml:"attempt_timeout"`
+			} `yaml:"retry"`
+			// 请求对冲, 第一份请求迟迟没有响应时向另一个节点发送第二份
+			Hedge struct {
+				Enabled bool `yaml:"enabled"`
+				// 发送第二份请求前等待的时间(毫秒)
+				Delay int `yaml:"delay"`
+				// 大于0时使用最近响应时间的该分位数作为等待时间, 样本不足时使用delay
+				Percentile float64 `yaml:"percentile"`
+				// 可以对冲的请求方法, 为空时只对冲幂等方法
+				Methods []string `yaml:"methods"`
+			} `yaml:"hedge"`
+			// 节点熔断, 最近请求失败、超时过多或过慢的节点暂停分发
+			CircuitBreaker struct {
+				Enabled bool `yaml:"enabled"`
+				// 统计最近多少次请求
+				Window int `yaml:"window"`
+				// 请求数不少于该值时才判断是否熔断
+				MinRequests int `yaml:"min_requests"`
+				// 失败率(含超时)、超时率阈值, 0为不限制
+				MaxFailureRate float64 `yaml:"max_failure_rate"`
+				MaxTimeoutRate float64 `yaml:"max_timeout_rate"`
+				// 平均响应时间阈值(毫秒), 0为不限制
+				MaxLatency int `yaml:"max_latency"`
+				// 熔断多久后开始探测(秒)
+				OpenDuration int `yaml:"open_duration"`
+				// 探测请求数, 全部成功后恢复
+				ProbeRequests int `yaml:"probe_requests"`
+			} `yaml:"circuit_breaker"`
+			// 节点都达到并发上限时请求排队等待
+			Queue struct {
+				// 排队的请求数上限, 0为不排队直接失败
+				Size int `yaml:"size"`
+				// 最长等待时间(秒)
+				Timeout int `yaml:"timeout"`
+				// 没有可用节点在线时请求等待节点上线的时间(秒), 0为直接失败
+				WaitForEdge int `yaml:"wait_for_edge"`
+				// 等待节点上线的请求数上限, 与Size分开计算, 0为使用默认值1024
+				WaitForEdgeSize int `yaml:"wait_for_edge_size"`
+				// 排队每超过该时间(秒)优先级提升一级
+				PriorityAging int `yaml:"priority_aging"`
+			} `yaml:"queue"`
+		} `yaml:"dispatch"`
+		// 异步任务接口 /api/jobs
+		Jobs struct {
+			// 任务完成后结果的保留时间(秒)
+			Ttl int `yaml:"ttl"`
+			// 保存的响应体长度上限(KB)
+			MaxBodySize int `yaml:"max_body_size"`
+			// 任务存储
+			Store struct {
+				// 存储类型: memory、bolt
+				Type string `yaml:"type"`
+				// bolt存储的文件路径
+				Path string `yaml:"path"`
+			} `yaml:"store"`
+			// 任务结果回调
+			Webhook struct {
+				// 最多尝试次数
+				MaxAttempts int `yaml:"max_attempts"`
+				// 第一次重试前的等待时间(秒), 之后每次翻倍
+				Backoff int `yaml:"backoff"`
+				// 单次回调的超时(秒)
+				Timeout int `yaml:"timeout"`
+			} `yaml:"webhook"`
+			// 批量提交 /api/batch
+			Batch struct {
+				// 一次提交的请求数上限
+				MaxRequests int `yaml:"max_requests"`
+				// 未指定时同时执行的请求数
+				Parallelism int `yaml:"parallelism"`
+				// 同时执行的请求数上限
+				MaxParallelism int `yaml:"max_parallelism"`
+				// 非stream模式汇总结果中响应体的总长度上限(KB)
+				MaxTotalBodySize int `yaml:"max_total_body_size"`
+				// 非stream模式的最长执行时间(秒)
+				Timeout int `yaml:"timeout"`
+			} `yaml:"batch"`
+		} `yaml:"jobs"`
+		// 启动时添加的定时任务, 也可以通过页面和 /api/schedules 管理
+		Schedules []struct {
+			Name string `yaml:"name"`
+			// cron表达式, 与interval二选一
+			Cron string `yaml:"cron"`
+			// 固定间隔(秒)
+			Interval int                 `yaml:"interval"`
+			Method   string              `yaml:"method"`
+			Url      string              `yaml:"url"`
+			Headers  map[string][]string `yaml:"headers"`
+			Body     string              `yaml:"body"`
+			// 超时时间(秒)
+			Timeout int               `yaml:"timeout"`
+			Labels  map[string]string `yaml:"labels"`
+			// 排队优先级: low、normal、high
+			Priority string `yaml:"priority"`
+		} `yaml:"schedules"`
+		// http和socks5代理的用户认证, 没有配置用户和用户文件时不需要认证
+		Auth struct {
+			// 用户名和密码, 密码可以是明文或bcrypt哈希
+			Users map[string]string `yaml:"users"`
+			// 用户文件, 每行为 用户名:bcrypt哈希, 修改后自动重新加载
+			File string `yaml:"file"`
+		} `yaml:"auth"`
+		// 中间人解密HTTPS使用的根证书, 文件不存在时自动生成
+		Ca struct {
+			Cert string `yaml:"cert"`
+			Key  string `yaml:"key"`
+			// 缓存的叶子证书数量
+			CacheSize int `yaml:"cache_size"`
+		} `yaml:"ca"`
+		// 隧道模式, 不做TLS解密, 由边缘节点连接目标并原样转发字节
+		Tunnel struct {
+			// 对应监听端口的所有连接都走隧道
+			HttpProxy   bool `yaml:"http_proxy"`
+			Socks5Proxy bool `yaml:"socks5_proxy"`
+			// 命中的目标地址走隧道, 支持example.com、*.example.com(可带端口)、regex:正则表达式和CIDR
+			Hosts []string `yaml:"hosts"`
+			// 禁止中间人解密的目标(证书固定的应用、银行等), 规则格式同hosts, 优先于其他配置
+			Bypass []string `yaml:"bypass"`
+		} `yaml:"tunnel"`
 	} `yaml:"server"`
 	Client struct {
 		// 客户端连接的服务端地址
@@ -31,6 +179,12 @@ type Config struct {
 		ServerPort          uint16 `yaml:"server_port"`
 		ServerAuthorization string `yaml:"server_authorization"`
 		ServerSecure        bool   `yaml:"server_secure"`
+		// 节点权重, 服务端使用weighted策略时生效
+		Weight int `yaml:"weight"`
+		// 节点标签, 代理客户端可以按标签选择节点
+		Labels map[string]string `yaml:"labels"`
+		// 同时处理的请求、隧道和UDP关联数上限, 0为不限制, 超出的请求在服务端排队
+		MaxInFlight int `yaml:"max_in_flight"`
 	} `yaml:"client"`
 }
 
@@ -7,4 +7,11 @@ const (
 	ErrorNoEdgeIdDefined
 	ErrorInvalidEdgeId
 	ErrorInvalidResponseMessageType
+	ErrorEdgeDisconnected
+	ErrorTunnelOpenFailed
+	ErrorNoMatchingEdge
+	ErrorEdgeCircuitOpen
+	ErrorEdgeQueueFull
+	ErrorEdgeQueueTimeout
+	ErrorEdgeExhausted
 )
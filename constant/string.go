@@ -1,5 +1,39 @@
 package constant
 
 const (
-	ConnSessionEdgeId = "EdgeId"
+	ConnSessionEdgeId   = "EdgeId"
+	ConnSessionEdgeInfo = "EdgeInfo"
+)
+
+// 边缘节点握手时通过请求头声明的信息
+const (
+	HeaderEdgeWeight = "X-Edge-Weight"
+	HeaderEdgeLabels = "X-Edge-Labels"
+	// HeaderEdgeMaxInFlight 节点同时处理的请求数上限
+	HeaderEdgeMaxInFlight = "X-Edge-Max-In-Flight"
+)
+
+// 返回给代理客户端的响应头
+const (
+	// HeaderAsyncAttempts 重试时每次尝试的节点、结果和耗时
+	HeaderAsyncAttempts = "X-Async-Attempts"
+	// HeaderAsyncEdge 处理请求的节点ID
+	HeaderAsyncEdge = "X-Async-Edge"
+)
+
+// 异步任务结果回调的请求头
+const (
+	// HeaderAsyncJob 任务ID
+	HeaderAsyncJob = "X-Async-Job"
+	// HeaderAsyncSignature 请求体的HMAC-SHA256签名, 形如 sha256=<hex>
+	HeaderAsyncSignature = "X-Async-Signature"
+)
+
+// 代理客户端使用的请求头, 转发给目标前会被移除
+const (
+	HeaderAsyncSession = "X-Async-Session"
+	// HeaderAsyncLabels 只使用带有这些标签的节点, 形如 region=cn-east,isp=telecom
+	HeaderAsyncLabels = "X-Async-Labels"
+	// HeaderAsyncPriority 节点繁忙排队时的优先级: low、normal、high
+	HeaderAsyncPriority = "X-Async-Priority"
 )
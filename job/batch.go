@@ -0,0 +1,242 @@
+package job
+
+import (
+	"asyncProxy/errors"
+	"asyncProxy/ws/edge"
+	"asyncProxy/ws/transport"
+	"context"
+	goerrors "errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBatchMaxRequests 一次批量提交的请求数上限
+	DefaultBatchMaxRequests = 1000
+	// DefaultBatchParallelism 未指定时同时执行的请求数
+	DefaultBatchParallelism = 16
+	// DefaultBatchMaxParallelism 同时执行的请求数上限
+	DefaultBatchMaxParallelism = 64
+	// DefaultBatchMaxTotalBodySize 非stream模式汇总结果中响应体的总长度上限
+	DefaultBatchMaxTotalBodySize = 64 * 1024 * 1024
+	// DefaultBatchTimeout 非stream模式的最长执行时间
+	DefaultBatchTimeout = 5 * time.Minute
+)
+
+// BatchRequest 批量提交的请求
+type BatchRequest struct {
+	Requests []Request `json:"requests"`
+	// Parallelism 同时执行的请求数, 为0时使用默认值
+	Parallelism int `json:"parallelism"`
+	// Stream 为true时每完成一个请求输出一行NDJSON, 否则全部完成后返回汇总结果
+	Stream bool `json:"stream"`
+}
+
+// ItemError 单个请求的错误, Code为BusinessError的错误码或HTTP状态码
+type ItemError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// BatchItem 单个请求的结果, Index为请求在数组中的下标
+type BatchItem struct {
+	Index     int                               `json:"index"`
+	Status    Status                            `json:"status"`
+	Response  *transport.WebsocketProxyResponse `json:"response,omitempty"`
+	Error     *ItemError                        `json:"error,omitempty"`
+	ElapsedMs int64                             `json:"elapsedMs"`
+}
+
+// BatchResult 批量请求的汇总结果, Items按请求顺序排列
+type BatchResult struct {
+	Total     int         `json:"total"`
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+	Items     []BatchItem `json:"items"`
+}
+
+// SetBatch 设置批量提交的请求数上限、默认并发数、并发数上限, 以及非stream模式的响应体总长度上限和最长执行时间, 为0时使用默认值
+func (m *Manager) SetBatch(maxRequests, parallelism, maxParallelism int, maxTotalBody int64, timeout time.Duration) {
+	if maxRequests <= 0 {
+		maxRequests = DefaultBatchMaxRequests
+	}
+	if maxParallelism <= 0 {
+		maxParallelism = DefaultBatchMaxParallelism
+	}
+	if parallelism <= 0 {
+		parallelism = DefaultBatchParallelism
+	}
+	if maxTotalBody <= 0 {
+		maxTotalBody = DefaultBatchMaxTotalBodySize
+	}
+	if timeout <= 0 {
+		timeout = DefaultBatchTimeout
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batchMaxRequests = maxRequests
+	m.batchParallelism = min(parallelism, maxParallelism)
+	m.batchMaxParallelism = maxParallelism
+	m.batchMaxTotalBody = maxTotalBody
+	m.batchTimeout = timeout
+}
+
+// BatchTimeout 非stream模式的最长执行时间
+func (m *Manager) BatchTimeout() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.batchTimeout
+}
+
+// ValidateBatch 检查请求数和并发数, 单个请求的错误在结果中返回
+func (m *Manager) ValidateBatch(batch *BatchRequest) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(batch.Requests) == 0 {
+		return goerrors.New("requests is empty")
+	}
+	if len(batch.Requests) > m.batchMaxRequests {
+		return fmt.Errorf("too many requests: %d > %d", len(batch.Requests), m.batchMaxRequests)
+	}
+	if batch.Parallelism < 0 {
+		return fmt.Errorf("invalid parallelism: %d", batch.Parallelism)
+	}
+	return nil
+}
+
+// RunBatch 按并发数把请求分发到边缘节点, 每完成一个请求调用一次emit, emit不会被并发调用.
+// ctx结束时取消未完成的请求
+func (m *Manager) RunBatch(ctx context.Context, batch *BatchRequest, emit func(item BatchItem)) {
+	m.mu.RLock()
+	parallelism := m.batchParallelism
+	if batch.Parallelism > 0 {
+		parallelism = min(batch.Parallelism, m.batchMaxParallelism)
+	}
+	m.mu.RUnlock()
+
+	var emitMu sync.Mutex
+	var wg sync.WaitGroup
+	tokens := make(chan struct{}, parallelism)
+	for i := range batch.Requests {
+		// ctx结束后分发会立即失败, 名额很快释放
+		tokens <- struct{}{}
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			item := m.runItem(ctx, index, &batch.Requests[index])
+			<-tokens
+			emitMu.Lock()
+			defer emitMu.Unlock()
+			emit(item)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// RunBatchAndWait 执行全部请求并返回汇总结果.
+// 响应体总长度超过上限后, 之后完成的请求不再保留响应体并标记为失败, 大量或较大的响应应使用stream模式
+func (m *Manager) RunBatchAndWait(ctx context.Context, batch *BatchRequest) *BatchResult {
+	m.mu.RLock()
+	limit := m.batchMaxTotalBody
+	m.mu.RUnlock()
+	remaining := limit
+	result := &BatchResult{Total: len(batch.Requests), Items: make([]BatchItem, len(batch.Requests))}
+	m.RunBatch(ctx, batch, func(item BatchItem) {
+		if item.Response != nil && len(item.Response.Body) > 0 {
+			if size := int64(len(item.Response.Body)); size > remaining {
+				item.Response.Body = nil
+				item.Status = StatusFailed
+				item.Error = &ItemError{Code: http.StatusRequestEntityTooLarge,
+					Message: fmt.Sprintf("汇总结果的响应体超过%d字节, 请使用stream模式", limit)}
+			} else {
+				remaining -= size
+			}
+		}
+		result.Items[item.Index] = item
+		if item.Status == StatusSucceeded {
+			result.Succeeded++
+		} else {
+			result.Failed++
+		}
+	})
+	return result
+}
+
+// Execute 同步执行单个请求并读取响应体
+func (m *Manager) Execute(ctx context.Context, request *Request) BatchItem {
+	return m.runItem(ctx, 0, request)
+}
+
+func (m *Manager) runItem(ctx context.Context, index int, request *Request) (item BatchItem) {
+	startAt := time.Now()
+	item = BatchItem{Index: index, Status: StatusFailed}
+	defer func() {
+		item.ElapsedMs = time.Since(startAt).Milliseconds()
+	}()
+	if e := request.Validate(); e != nil {
+		item.Error = &ItemError{Code: http.StatusBadRequest, Message: e.Error()}
+		return item
+	}
+	body := request.body()
+	response, e := m.edges.DispatchRequestContext(ctx, &edge.Request{
+		Method:  request.Method,
+		Url:     request.Url,
+		Headers: request.Headers,
+		Body:    body,
+		Timeout: request.timeout(),
+		Options: request.options(),
+	})
+	if e != nil {
+		item.Error = itemError(e)
+		return item
+	}
+	// ctx结束时中止响应体的读取
+	if body := response.BodyReader; body != nil {
+		stop := context.AfterFunc(ctx, func() {
+			_ = body.Close()
+		})
+		defer stop()
+	}
+	message := m.readBody(response, request.timeout())
+	item.Response = response
+	switch {
+	case !response.Success:
+		item.Error = &ItemError{Code: edgeErrorCode(response.ErrorMessage), Message: response.ErrorMessage}
+	case message != "" && ctx.Err() != nil:
+		item.Error = itemError(ctx.Err())
+	case message != "":
+		item.Error = &ItemError{Code: http.StatusBadGateway, Message: message}
+	default:
+		item.Status = StatusSucceeded
+	}
+	return item
+}
+
+// itemError 业务错误保留错误码, 其他错误按HTTP状态码返回
+func itemError(e error) *ItemError {
+	var businessError *errors.BusinessError
+	switch {
+	case goerrors.As(e, &businessError):
+		return &ItemError{Code: businessError.Code, Message: businessError.Message}
+	case goerrors.Is(e, context.Canceled):
+		return &ItemError{Code: 499, Message: "请求已取消"}
+	case goerrors.Is(e, context.DeadlineExceeded):
+		return &ItemError{Code: http.StatusGatewayTimeout, Message: e.Error()}
+	default:
+		return &ItemError{Code: http.StatusInternalServerError, Message: e.Error()}
+	}
+}
+
+// edgeErrorCode 边缘节点返回的失败按HTTP状态码返回
+func edgeErrorCode(message string) int {
+	switch message {
+	case edge.ErrorMessageTimeout:
+		return http.StatusGatewayTimeout
+	case edge.ErrorMessageCancelled:
+		return 499
+	default:
+		return http.StatusBadGateway
+	}
+}
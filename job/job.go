@@ -0,0 +1,369 @@
+package job
+
+import (
+	"asyncProxy/ws/edge"
+	"asyncProxy/ws/transport"
+	goerrors "errors"
+	"fmt"
+	"github.com/oklog/ulid/v2"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTtl 任务完成后结果的默认保留时间
+	DefaultTtl = time.Hour
+	// DefaultMaxBodySize 默认保存的响应体长度上限
+	DefaultMaxBodySize = 10 * 1024 * 1024
+	// restoreWait 重启后重新分发任务前最多等待节点上线的时间
+	restoreWait = time.Minute
+)
+
+// Status 任务状态
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Request 提交的请求
+type Request struct {
+	Method  string              `json:"method"`
+	Url     string              `json:"url"`
+	Headers map[string][]string `json:"headers"`
+	Body    string              `json:"body"`
+	// Timeout 等待响应的超时(秒), 为0时使用默认值
+	Timeout int `json:"timeout"`
+	// Session 会话键, 同一会话的任务走同一个节点
+	Session string `json:"session"`
+	// Labels 只使用带有这些标签的节点
+	Labels map[string]string `json:"labels"`
+	// Priority 节点繁忙排队时的优先级: low、normal、high
+	Priority string `json:"priority"`
+	// Callback 任务完成后POST结果的地址, 为空时只能轮询
+	Callback string `json:"callback"`
+	// CallbackSecret 回调请求体的HMAC-SHA256签名密钥, 签名放在X-Async-Signature请求头
+	CallbackSecret string `json:"callbackSecret"`
+}
+
+// Validate 检查请求并补全默认值
+func (r *Request) Validate() error {
+	if r.Method == "" {
+		r.Method = "GET"
+	}
+	r.Method = strings.ToUpper(r.Method)
+	u, e := url.Parse(r.Url)
+	if e != nil {
+		return fmt.Errorf("invalid url: %w", e)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url: %s", r.Url)
+	}
+	if r.Timeout < 0 {
+		return fmt.Errorf("invalid timeout: %d", r.Timeout)
+	}
+	if _, e := edge.ParsePriority(r.Priority); e != nil {
+		return e
+	}
+	if r.Callback != "" {
+		u, e := url.Parse(r.Callback)
+		if e != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid callback: %s", r.Callback)
+		}
+	}
+	return nil
+}
+
+func (r *Request) timeout() time.Duration {
+	if r.Timeout <= 0 {
+		return edge.DefaultAttemptTimeout
+	}
+	return time.Duration(r.Timeout) * time.Second
+}
+
+// body 请求体, 没有声明长度时补上Content-Length
+func (r *Request) body() io.Reader {
+	if r.Body == "" {
+		return nil
+	}
+	if http.Header(r.Headers).Get("Content-Length") == "" {
+		// 复制后再修改, 定时任务的请求会被重复使用
+		headers := http.Header(r.Headers).Clone()
+		if headers == nil {
+			headers = http.Header{}
+		}
+		headers.Set("Content-Length", strconv.Itoa(len(r.Body)))
+		r.Headers = headers
+	}
+	return strings.NewReader(r.Body)
+}
+
+func (r *Request) options() *edge.DispatchOptions {
+	priority, _ := edge.ParsePriority(r.Priority)
+	return &edge.DispatchOptions{SessionKey: r.Session, Labels: r.Labels, Priority: priority}
+}
+
+// Job 异步任务及其结果
+type Job struct {
+	Id         string     `json:"id"`
+	Status     Status     `json:"status"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// Response 边缘节点的响应, 响应体保存在Body中
+	Response *transport.WebsocketProxyResponse `json:"response,omitempty"`
+	Error    string                            `json:"error,omitempty"`
+	// Delivery 结果回调的投递状态, 没有回调地址时为空
+	Delivery *Delivery `json:"delivery,omitempty"`
+
+	request *Request
+}
+
+// Manager 通过边缘节点异步执行请求, 保存结果直到过期
+type Manager struct {
+	edges       *edge.EdgeSet
+	ttl         time.Duration
+	maxBodySize int64
+
+	webhookAttempts int
+	webhookBackoff  time.Duration
+	webhookClient   *http.Client
+
+	batchMaxRequests    int
+	batchParallelism    int
+	batchMaxParallelism int
+	batchMaxTotalBody   int64
+	batchTimeout        time.Duration
+
+	// mu 保证同一任务的保存顺序与状态变化顺序一致
+	mu      sync.RWMutex
+	store   JobStore
+	sweptAt time.Time
+}
+
+// NewManager ttl为任务完成后结果的保留时间, maxBodySize为保存的响应体长度上限, 为0时使用默认值
+func NewManager(edges *edge.EdgeSet, store JobStore, ttl time.Duration, maxBodySize int64) *Manager {
+	if ttl <= 0 {
+		ttl = DefaultTtl
+	}
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+	return &Manager{
+		edges:               edges,
+		ttl:                 ttl,
+		maxBodySize:         maxBodySize,
+		webhookAttempts:     DefaultWebhookAttempts,
+		webhookBackoff:      DefaultWebhookBackoff,
+		webhookClient:       &http.Client{Timeout: DefaultWebhookTimeout},
+		batchMaxRequests:    DefaultBatchMaxRequests,
+		batchParallelism:    DefaultBatchParallelism,
+		batchMaxParallelism: DefaultBatchMaxParallelism,
+		batchMaxTotalBody:   DefaultBatchMaxTotalBodySize,
+		batchTimeout:        DefaultBatchTimeout,
+		store:               store,
+		sweptAt:             time.Now(),
+	}
+}
+
+// Submit 保存任务并在后台分发请求, 立即返回pending状态的任务. 所有节点繁忙时任务在后台排队, 无法分发时任务失败
+func (m *Manager) Submit(request *Request) (Job, error) {
+	if e := request.Validate(); e != nil {
+		return Job{}, e
+	}
+	job := &Job{Id: ulid.Make().String(), Status: StatusPending, CreatedAt: time.Now(), request: request}
+	if request.Callback != "" {
+		job.Delivery = &Delivery{Status: DeliveryPending}
+	}
+
+	m.mu.Lock()
+	m.sweepLocked()
+	m.saveLocked(job)
+	submitted := *job
+	m.mu.Unlock()
+
+	go func() {
+		if e := m.dispatch(job); e != nil {
+			m.fail(job, e)
+		}
+	}()
+	return submitted, nil
+}
+
+// dispatch 分发任务的请求, 完成后保存结果并投递回调
+func (m *Manager) dispatch(job *Job) error {
+	request := job.request
+	body := request.body()
+	_, e := m.edges.DispatchRequestAsync(&edge.Request{
+		Method:  request.Method,
+		Url:     request.Url,
+		Headers: request.Headers,
+		Body:    body,
+		Timeout: request.timeout(),
+		Options: request.options(),
+	},
+		func(response *transport.WebsocketProxyResponse) {
+			go func() {
+				m.complete(job, response)
+				if request.Callback != "" {
+					m.deliver(job)
+				}
+			}()
+		})
+	return e
+}
+
+// Get 查询任务, 不存在或已过期时返回false
+func (m *Manager) Get(id string) (Job, bool) {
+	record, e := m.store.Get(id)
+	if e != nil {
+		log.Println("读取任务失败:", id, e)
+		return Job{}, false
+	}
+	if record == nil || m.expired(&record.Job, time.Now()) {
+		return Job{}, false
+	}
+	return record.Job, true
+}
+
+// readBody 把流式响应体读入response.Body, 返回失败原因. 超过idle没有读到数据时中止读取, 任务失败
+func (m *Manager) readBody(response *transport.WebsocketProxyResponse, idle time.Duration) string {
+	if response.BodyReader == nil {
+		return ""
+	}
+	reader := transport.NewStallReader(response.BodyReader, idle)
+	body, e := io.ReadAll(io.LimitReader(reader, m.maxBodySize+1))
+	_ = reader.Close()
+	response.BodyReader = nil
+	response.Body = body
+	switch {
+	case goerrors.Is(e, transport.ErrBodyStalled):
+		return fmt.Sprintf("读取响应体失败: 超过%s没有收到数据", idle)
+	case e != nil:
+		return "读取响应体失败: " + e.Error()
+	case int64(len(body)) > m.maxBodySize:
+		response.Body = body[:m.maxBodySize]
+		return fmt.Sprintf("响应体超过%d字节", m.maxBodySize)
+	}
+	return ""
+}
+
+// complete 读取响应体并保存结果
+func (m *Manager) complete(job *Job, response *transport.WebsocketProxyResponse) {
+	status := StatusSucceeded
+	message := m.readBody(response, job.request.timeout())
+	if !response.Success {
+		message = response.ErrorMessage
+	}
+	if message != "" {
+		status = StatusFailed
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job.Status = status
+	job.Error = message
+	job.Response = response
+	job.FinishedAt = &now
+	m.saveLocked(job)
+}
+
+// fail 任务无法分发
+func (m *Manager) fail(job *Job, e error) {
+	now := time.Now()
+	m.mu.Lock()
+	job.Status = StatusFailed
+	job.Error = itemError(e).Message
+	job.FinishedAt = &now
+	m.saveLocked(job)
+	m.mu.Unlock()
+	if job.request.Callback != "" {
+		m.deliver(job)
+	}
+}
+
+func (m *Manager) saveLocked(job *Job) {
+	if e := m.store.Save(&Record{Job: *job, Request: *job.request}); e != nil {
+		log.Println("保存任务失败:", job.Id, e)
+	}
+}
+
+func (m *Manager) expired(job *Job, now time.Time) bool {
+	return job.FinishedAt != nil && now.Sub(*job.FinishedAt) > m.ttl
+}
+
+// sweepLocked 定期清理过期的任务
+func (m *Manager) sweepLocked() {
+	now := time.Now()
+	if now.Sub(m.sweptAt) < time.Minute {
+		return
+	}
+	m.sweptAt = now
+	var expired []string
+	e := m.store.Range(func(record *Record) bool {
+		if m.expired(&record.Job, now) {
+			expired = append(expired, record.Job.Id)
+		}
+		return true
+	})
+	if e != nil {
+		log.Println("清理过期任务失败:", e)
+	}
+	for _, id := range expired {
+		if e := m.store.Delete(id); e != nil {
+			log.Println("删除过期任务失败:", id, e)
+		}
+	}
+}
+
+// Restore 服务端启动时重新分发存储中未完成的任务, 并继续投递未送达的回调.
+// 重启前可能已经发出的请求会再执行一次, 等待边缘节点上线后再分发
+func (m *Manager) Restore() error {
+	var pending, undelivered []*Job
+	e := m.store.Range(func(record *Record) bool {
+		job, request := record.Job, record.Request
+		job.request = &request
+		switch {
+		case job.Status == StatusPending:
+			pending = append(pending, &job)
+		case job.Delivery != nil && job.Delivery.Status == DeliveryPending && !m.expired(&job, time.Now()):
+			undelivered = append(undelivered, &job)
+		}
+		return true
+	})
+	if e != nil {
+		return e
+	}
+	for _, job := range undelivered {
+		go m.deliver(job)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	log.Println("重新分发未完成的任务数为:", len(pending))
+	go func() {
+		m.waitForEdge(restoreWait)
+		for _, job := range pending {
+			if e := m.dispatch(job); e != nil {
+				m.fail(job, e)
+			}
+		}
+	}()
+	return nil
+}
+
+// waitForEdge 等待至少一个边缘节点上线, 最多等待timeout
+func (m *Manager) waitForEdge(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for m.edges.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,165 @@
+package job
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Record 保存的任务和提交时的请求, 未完成的任务在服务端重启后按请求重新分发
+type Record struct {
+	Job     Job     `json:"job"`
+	Request Request `json:"request"`
+}
+
+// JobStore 任务的存储
+type JobStore interface {
+	// Save 新增或覆盖任务
+	Save(record *Record) error
+	// Get 任务不存在时返回nil, nil
+	Get(id string) (*Record, error)
+	Delete(id string) error
+	// Range 遍历所有任务, fn返回false时停止, 遍历过程中不能修改存储
+	Range(fn func(record *Record) bool) error
+	Close() error
+}
+
+// NewJobStore 按类型创建存储: memory(默认)或bolt, bolt使用path指定的文件
+func NewJobStore(storeType, path string) (JobStore, error) {
+	switch storeType {
+	case "", "memory":
+		return NewMemoryJobStore(), nil
+	case "bolt":
+		return NewBoltJobStore(path)
+	default:
+		return nil, fmt.Errorf("unknown job store: %s", storeType)
+	}
+}
+
+// MemoryJobStore 保存在内存中, 重启后丢失
+type MemoryJobStore struct {
+	mu      sync.RWMutex
+	records map[string]Record
+}
+
+func NewMemoryJobStore() *MemoryJobStore {
+	return &MemoryJobStore{records: map[string]Record{}}
+}
+
+func (s *MemoryJobStore) Save(record *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.Job.Id] = *record
+	return nil
+}
+
+func (s *MemoryJobStore) Get(id string) (*Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	record, ok := s.records[id]
+	if !ok {
+		return nil, nil
+	}
+	return &record, nil
+}
+
+func (s *MemoryJobStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, id)
+	return nil
+}
+
+func (s *MemoryJobStore) Range(fn func(record *Record) bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, record := range s.records {
+		if !fn(&record) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *MemoryJobStore) Close() error {
+	return nil
+}
+
+var jobsBucket = []byte("jobs")
+
+// BoltJobStore 以JSON保存在bbolt文件中, 重启后保留
+type BoltJobStore struct {
+	db *bbolt.DB
+}
+
+func NewBoltJobStore(path string) (*BoltJobStore, error) {
+	if e := os.MkdirAll(filepath.Dir(path), 0o755); e != nil {
+		return nil, e
+	}
+	db, e := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: 5 * time.Second})
+	if e != nil {
+		return nil, e
+	}
+	e = db.Update(func(tx *bbolt.Tx) error {
+		_, e := tx.CreateBucketIfNotExists(jobsBucket)
+		return e
+	})
+	if e != nil {
+		_ = db.Close()
+		return nil, e
+	}
+	return &BoltJobStore{db: db}, nil
+}
+
+func (s *BoltJobStore) Save(record *Record) error {
+	value, e := json.Marshal(record)
+	if e != nil {
+		return e
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(record.Job.Id), value)
+	})
+}
+
+func (s *BoltJobStore) Get(id string) (*Record, error) {
+	var record *Record
+	e := s.db.View(func(tx *bbolt.Tx) error {
+		value := tx.Bucket(jobsBucket).Get([]byte(id))
+		if value == nil {
+			return nil
+		}
+		record = &Record{}
+		return json.Unmarshal(value, record)
+	})
+	return record, e
+}
+
+func (s *BoltJobStore) Delete(id string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(jobsBucket).Delete([]byte(id))
+	})
+}
+
+func (s *BoltJobStore) Range(fn func(record *Record) bool) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(jobsBucket).Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			var record Record
+			if e := json.Unmarshal(value, &record); e != nil {
+				return fmt.Errorf("decode job %s: %w", key, e)
+			}
+			if !fn(&record) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltJobStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,159 @@
+package job
+
+import (
+	"asyncProxy/constant"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultWebhookAttempts 回调的默认最多尝试次数
+	DefaultWebhookAttempts = 5
+	// DefaultWebhookBackoff 回调失败后第一次重试前的等待时间, 之后每次翻倍
+	DefaultWebhookBackoff = time.Second
+	// DefaultWebhookTimeout 单次回调的超时
+	DefaultWebhookTimeout = 10 * time.Second
+	// maxWebhookBackoff 重试等待时间的上限
+	maxWebhookBackoff = time.Minute
+)
+
+// DeliveryStatus 回调投递状态
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// Delivery 任务结果的回调投递记录
+type Delivery struct {
+	Status      DeliveryStatus `json:"status"`
+	Attempts    int            `json:"attempts"`
+	LastError   string         `json:"lastError,omitempty"`
+	DeliveredAt *time.Time     `json:"deliveredAt,omitempty"`
+}
+
+// webhookPayload 回调请求体, 响应体以base64编码
+type webhookPayload struct {
+	Id         string              `json:"id"`
+	Status     Status              `json:"status"`
+	Error      string              `json:"error,omitempty"`
+	EdgeId     string              `json:"edgeId"`
+	StatusCode int                 `json:"statusCode"`
+	Headers    map[string][]string `json:"headers"`
+	Body       []byte              `json:"body"`
+	CreatedAt  time.Time           `json:"createdAt"`
+	FinishedAt time.Time           `json:"finishedAt"`
+	// ElapsedMs 从提交到完成的耗时(毫秒)
+	ElapsedMs int64 `json:"elapsedMs"`
+}
+
+// SetWebhook 设置回调的最多尝试次数、第一次重试的等待时间和单次超时, 为0时使用默认值
+func (m *Manager) SetWebhook(maxAttempts int, backoff, timeout time.Duration) {
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultWebhookAttempts
+	}
+	if backoff <= 0 {
+		backoff = DefaultWebhookBackoff
+	}
+	if timeout <= 0 {
+		timeout = DefaultWebhookTimeout
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.webhookAttempts = maxAttempts
+	m.webhookBackoff = backoff
+	m.webhookClient = &http.Client{Timeout: timeout}
+}
+
+// deliver 把任务结果POST到回调地址, 失败时按指数退避重试
+func (m *Manager) deliver(job *Job) {
+	callback, secret := job.request.Callback, job.request.CallbackSecret
+	m.mu.RLock()
+	payload := webhookPayload{
+		Id:         job.Id,
+		Status:     job.Status,
+		Error:      job.Error,
+		CreatedAt:  job.CreatedAt,
+		FinishedAt: *job.FinishedAt,
+		ElapsedMs:  job.FinishedAt.Sub(job.CreatedAt).Milliseconds(),
+	}
+	// 没有分发成功的任务没有响应
+	if response := job.Response; response != nil {
+		payload.EdgeId = response.EdgeId
+		payload.StatusCode = response.StatusCode
+		payload.Headers = response.Headers
+		payload.Body = response.Body
+	}
+	attempts, backoff, client := m.webhookAttempts, m.webhookBackoff, m.webhookClient
+	m.mu.RUnlock()
+
+	body, _ := json.Marshal(&payload)
+	for attempt := 1; ; attempt++ {
+		e := post(client, callback, secret, payload.Id, body)
+		if e == nil {
+			m.recordDelivery(job, DeliveryDelivered, nil)
+			return
+		}
+		if attempt >= attempts {
+			log.Println("任务结果回调失败:", payload.Id, e)
+			m.recordDelivery(job, DeliveryFailed, e)
+			return
+		}
+		m.recordDelivery(job, DeliveryPending, e)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxWebhookBackoff)
+	}
+}
+
+// post 发送一次回调, 配置了secret时请求头带上请求体的HMAC-SHA256签名
+func post(client *http.Client, callback, secret, id string, body []byte) error {
+	request, e := http.NewRequest(http.MethodPost, callback, bytes.NewReader(body))
+	if e != nil {
+		return e
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(constant.HeaderAsyncJob, id)
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		request.Header.Set(constant.HeaderAsyncSignature, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	response, e := client.Do(request)
+	if e != nil {
+		return e
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("callback returned status %d", response.StatusCode)
+	}
+	return nil
+}
+
+func (m *Manager) recordDelivery(job *Job, status DeliveryStatus, e error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delivery := *job.Delivery
+	delivery.Status = status
+	delivery.Attempts++
+	delivery.LastError = ""
+	if e != nil {
+		delivery.LastError = e.Error()
+	}
+	if status == DeliveryDelivered {
+		now := time.Now()
+		delivery.DeliveredAt = &now
+	}
+	job.Delivery = &delivery
+	m.saveLocked(job)
+}
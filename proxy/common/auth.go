@@ -0,0 +1,155 @@
+package common
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// userFileCheckInterval 检查用户文件是否修改的最短间隔
+const userFileCheckInterval = time.Second
+
+// UserStore 代理用户, 包括配置文件中的静态用户和用户文件中的用户, 既没有静态用户也没有用户文件时不需要认证
+type UserStore struct {
+	static map[string]string
+	file   string
+
+	mu        sync.RWMutex
+	fileUsers map[string]string
+	modTime   time.Time
+	checkedAt time.Time
+	// verified 校验通过的密码摘要, 避免每个请求都计算bcrypt
+	verified map[[sha256.Size]byte]bool
+}
+
+// NewUserStore users为用户名和密码, 密码可以是明文或bcrypt哈希.
+// file为用户文件, 每行为 用户名:bcrypt哈希(可由 htpasswd -nbB 生成), 修改后自动重新加载
+func NewUserStore(users map[string]string, file string) (*UserStore, error) {
+	s := &UserStore{static: users, file: file, verified: map[[sha256.Size]byte]bool{}}
+	if file != "" {
+		if e := s.load(); e != nil {
+			return nil, e
+		}
+	}
+	return s, nil
+}
+
+// Enabled 是否需要认证, 配置了用户文件时即使文件中没有用户也需要认证
+func (s *UserStore) Enabled() bool {
+	return s != nil && (len(s.static) > 0 || s.file != "")
+}
+
+// Authenticate 校验代理用户名和密码, 用户名可以带 ;session=abc 等参数. 没有配置用户时总是通过
+func (s *UserStore) Authenticate(rawUsername, password string) bool {
+	if !s.Enabled() {
+		return true
+	}
+	s.reloadIfChanged()
+	username, _ := ParseUsername(rawUsername)
+	s.mu.RLock()
+	expected, ok := s.fileUsers[username]
+	if !ok {
+		expected, ok = s.static[username]
+	}
+	s.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	if !isBcryptHash(expected) {
+		return subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+	}
+
+	digest := sha256.Sum256([]byte(username + "\x00" + password + "\x00" + expected))
+	s.mu.RLock()
+	cached := s.verified[digest]
+	s.mu.RUnlock()
+	if cached {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(expected), []byte(password)) != nil {
+		return false
+	}
+	s.mu.Lock()
+	s.verified[digest] = true
+	s.mu.Unlock()
+	return true
+}
+
+func (s *UserStore) reloadIfChanged() {
+	if s.file == "" {
+		return
+	}
+	s.mu.Lock()
+	if time.Since(s.checkedAt) < userFileCheckInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.checkedAt = time.Now()
+	modTime := s.modTime
+	s.mu.Unlock()
+
+	info, e := os.Stat(s.file)
+	if e != nil || info.ModTime().Equal(modTime) {
+		return
+	}
+	if e := s.load(); e != nil {
+		log.Println("加载代理用户文件失败, 继续使用之前的用户:", e)
+		// 文件再次修改前不重复加载
+		s.mu.Lock()
+		s.modTime = info.ModTime()
+		s.mu.Unlock()
+	}
+}
+
+// load 读取用户文件, 出错或文件中没有用户时保留之前的用户
+func (s *UserStore) load() error {
+	file, e := os.Open(s.file)
+	if e != nil {
+		return e
+	}
+	defer file.Close()
+	info, e := file.Stat()
+	if e != nil {
+		return e
+	}
+
+	users := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(text, ":")
+		if !ok || username == "" || !isBcryptHash(hash) {
+			return fmt.Errorf("%s:%d: expect username:bcrypt-hash", s.file, line)
+		}
+		users[username] = hash
+	}
+	if e := scanner.Err(); e != nil {
+		return e
+	}
+	// 编辑器保存时可能先清空文件, 不能因此变为没有用户
+	if len(users) == 0 {
+		return fmt.Errorf("%s: no users", s.file)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fileUsers = users
+	s.modTime = info.ModTime()
+	s.verified = map[[sha256.Size]byte]bool{}
+	log.Printf("加载了%d个代理用户: %s\n", len(users), s.file)
+	return nil
+}
+
+func isBcryptHash(value string) bool {
+	return strings.HasPrefix(value, "$2a$") || strings.HasPrefix(value, "$2b$") || strings.HasPrefix(value, "$2y$")
+}
@@ -0,0 +1,139 @@
+package common
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testBcrypt(t *testing.T, password string) string {
+	t.Helper()
+	hash, e := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return string(hash)
+}
+
+// writeUsers 写入用户文件并修改修改时间, 使下一次认证重新加载
+func writeUsers(t *testing.T, store *UserStore, path, content string, modTime time.Time) {
+	t.Helper()
+	if e := os.WriteFile(path, []byte(content), 0o600); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.Chtimes(path, modTime, modTime); e != nil {
+		t.Fatal(e)
+	}
+	if store != nil {
+		store.mu.Lock()
+		store.checkedAt = time.Time{}
+		store.mu.Unlock()
+	}
+}
+
+func TestUserStoreStatic(t *testing.T) {
+	store, e := NewUserStore(map[string]string{"alice": "plain", "bob": testBcrypt(t, "hashed")}, "")
+	if e != nil {
+		t.Fatal(e)
+	}
+	tests := []struct {
+		username string
+		password string
+		want     bool
+	}{
+		{"alice", "plain", true},
+		{"alice;session=abc", "plain", true},
+		{"alice", "wrong", false},
+		{"bob", "hashed", true},
+		// 第二次命中缓存的校验结果
+		{"bob", "hashed", true},
+		{"bob", "wrong", false},
+		{"carol", "plain", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got := store.Authenticate(tt.username, tt.password); got != tt.want {
+			t.Fatalf("Authenticate(%q, %q) = %v, want %v", tt.username, tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestUserStoreDisabled(t *testing.T) {
+	var nilStore *UserStore
+	store, e := NewUserStore(nil, "")
+	if e != nil {
+		t.Fatal(e)
+	}
+	for _, s := range []*UserStore{nilStore, store} {
+		if s.Enabled() || !s.Authenticate("anyone", "") {
+			t.Fatal("store without users requires authentication")
+		}
+	}
+}
+
+func TestUserStoreFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	start := time.Now().Add(-time.Hour)
+	writeUsers(t, nil, path, "# users\n\nalice:"+testBcrypt(t, "v1")+"\n", start)
+	store, e := NewUserStore(map[string]string{"static": "pass"}, path)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	steps := []struct {
+		name    string
+		content string
+		// want 各用户名和密码的认证结果
+		want map[[2]string]bool
+	}{
+		{"initial", "", map[[2]string]bool{{"alice", "v1"}: true, {"static", "pass"}: true, {"bob", "v2"}: false}},
+		{"reload on change", "alice:" + testBcrypt(t, "v2") + "\nbob:" + testBcrypt(t, "v2") + "\n",
+			map[[2]string]bool{{"alice", "v1"}: false, {"alice", "v2"}: true, {"bob", "v2"}: true}},
+		// 清空文件时保留之前的用户, 仍然需要认证
+		{"emptied keeps users", "",
+			map[[2]string]bool{{"alice", "v2"}: true, {"bob", "v2"}: true, {"nobody", ""}: false}},
+		{"invalid keeps users", "alice:plaintext\n",
+			map[[2]string]bool{{"alice", "v2"}: true, {"alice", "plaintext"}: false}},
+	}
+	for i, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if i > 0 {
+				writeUsers(t, store, path, step.content, start.Add(time.Duration(i)*time.Minute))
+			}
+			if !store.Enabled() {
+				t.Fatal("auth disabled")
+			}
+			for credentials, want := range step.want {
+				if got := store.Authenticate(credentials[0], credentials[1]); got != want {
+					t.Fatalf("Authenticate(%q, %q) = %v, want %v", credentials[0], credentials[1], got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewUserStoreFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"empty", "# no users\n"},
+		{"plaintext password", "alice:secret\n"},
+		{"missing separator", "alice\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			writeUsers(t, nil, path, tt.content, time.Now())
+			if _, e := NewUserStore(nil, path); e == nil {
+				t.Fatal("invalid users file accepted")
+			}
+		})
+	}
+	if _, e := NewUserStore(nil, filepath.Join(dir, "missing")); e == nil {
+		t.Fatal("missing users file accepted")
+	}
+}
@@ -0,0 +1,276 @@
+package common
+
+import (
+	"container/list"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	goerrors "errors"
+	"fmt"
+	"golang.org/x/sync/singleflight"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLeafCacheSize 缓存的叶子证书数量
+	DefaultLeafCacheSize = 1024
+	// leafValidity 叶子证书有效期, 不超过浏览器接受的398天
+	leafValidity = 397 * 24 * time.Hour
+	// caValidity 生成的根证书有效期
+	caValidity = 10 * 365 * 24 * time.Hour
+)
+
+// CertificateAuthority 中间人解密使用的根证书, 按客户端请求的主机名签发叶子证书
+type CertificateAuthority struct {
+	cert    *x509.Certificate
+	certPem []byte
+	key     crypto.Signer
+	cache   *leafCache
+}
+
+// LoadOrCreateCA 从文件加载根证书和私钥, 文件不存在时生成ECDSA根证书并保存.
+// 需要在客户端信任该根证书才能解密HTTPS
+func LoadOrCreateCA(certPath, keyPath string, cacheSize int) (*CertificateAuthority, error) {
+	certPem, e := os.ReadFile(certPath)
+	if goerrors.Is(e, os.ErrNotExist) {
+		log.Println("根证书不存在, 生成新的根证书:", certPath)
+		return createCA(certPath, keyPath, cacheSize)
+	}
+	if e != nil {
+		return nil, e
+	}
+	keyPem, e := os.ReadFile(keyPath)
+	if e != nil {
+		return nil, e
+	}
+	pair, e := tls.X509KeyPair(certPem, keyPem)
+	if e != nil {
+		return nil, fmt.Errorf("load ca: %w", e)
+	}
+	cert, e := x509.ParseCertificate(pair.Certificate[0])
+	if e != nil {
+		return nil, fmt.Errorf("parse ca: %w", e)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("%s is not a ca certificate", certPath)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported ca key: %T", pair.PrivateKey)
+	}
+	return &CertificateAuthority{cert: cert, certPem: certPem, key: key, cache: newLeafCache(cacheSize)}, nil
+}
+
+func createCA(certPath, keyPath string, cacheSize int) (*CertificateAuthority, error) {
+	key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e != nil {
+		return nil, e
+	}
+	serial, e := randomSerial()
+	if e != nil {
+		return nil, e
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "asyncProxy CA", Organization: []string{"asyncProxy"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLenZero:        true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+	der, e := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if e != nil {
+		return nil, e
+	}
+	cert, e := x509.ParseCertificate(der)
+	if e != nil {
+		return nil, e
+	}
+	keyDer, e := x509.MarshalECPrivateKey(key)
+	if e != nil {
+		return nil, e
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	for _, path := range []string{certPath, keyPath} {
+		if e := os.MkdirAll(filepath.Dir(path), 0o755); e != nil {
+			return nil, e
+		}
+	}
+	if e := os.WriteFile(keyPath, keyPem, 0o600); e != nil {
+		return nil, e
+	}
+	if e := os.WriteFile(certPath, certPem, 0o644); e != nil {
+		return nil, e
+	}
+	return &CertificateAuthority{cert: cert, certPem: certPem, key: key, cache: newLeafCache(cacheSize)}, nil
+}
+
+// TLSConfig 中间人解密的TLS配置, 按SNI签发证书, 客户端没有发送SNI时使用defaultHost
+func (ca *CertificateAuthority) TLSConfig(defaultHost string) *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			host := hello.ServerName
+			if host == "" {
+				host = defaultHost
+			}
+			return ca.leaf(host)
+		},
+		NextProtos: []string{
+			"h2",
+			"http/1.1",
+		},
+	}
+}
+
+// leaf 取出主机名对应的叶子证书, 没有缓存或即将过期时重新签发
+func (ca *CertificateAuthority) leaf(host string) (*tls.Certificate, error) {
+	if h, _, e := net.SplitHostPort(host); e == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return nil, goerrors.New("no host to issue certificate for")
+	}
+	return ca.cache.getOrCreate(host, func() (*tls.Certificate, error) {
+		return ca.issue(host)
+	})
+}
+
+func (ca *CertificateAuthority) issue(host string) (*tls.Certificate, error) {
+	key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e != nil {
+		return nil, e
+	}
+	serial, e := randomSerial()
+	if e != nil {
+		return nil, e
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if template.NotAfter.After(ca.cert.NotAfter) {
+		template.NotAfter = ca.cert.NotAfter
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, e := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if e != nil {
+		return nil, e
+	}
+	leaf, e := x509.ParseCertificate(der)
+	if e != nil {
+		return nil, e
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// leafCache 按主机名缓存叶子证书, 超过容量时淘汰最久未使用的
+type leafCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+	// issuing 同一主机名的并发握手只签发一次
+	issuing singleflight.Group
+}
+
+type leafEntry struct {
+	host string
+	cert *tls.Certificate
+}
+
+func newLeafCache(capacity int) *leafCache {
+	if capacity <= 0 {
+		capacity = DefaultLeafCacheSize
+	}
+	return &leafCache{capacity: capacity, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// getOrCreate 签发在锁外进行, 不同主机名的签发互不阻塞, 同一主机名的并发握手只签发一次
+func (c *leafCache) getOrCreate(host string, create func() (*tls.Certificate, error)) (*tls.Certificate, error) {
+	if cert, ok := c.get(host); ok {
+		return cert, nil
+	}
+	value, e, _ := c.issuing.Do(host, func() (any, error) {
+		// 等待期间可能已有其他握手签发完成
+		if cert, ok := c.get(host); ok {
+			return cert, nil
+		}
+		cert, e := create()
+		if e != nil {
+			return nil, e
+		}
+		c.put(host, cert)
+		return cert, nil
+	})
+	if e != nil {
+		return nil, e
+	}
+	return value.(*tls.Certificate), nil
+}
+
+// get 返回缓存中距离过期超过一小时的证书
+func (c *leafCache) get(host string) (*tls.Certificate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[host]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*leafEntry)
+	if time.Until(entry.cert.Leaf.NotAfter) <= time.Hour {
+		c.order.Remove(element)
+		delete(c.entries, host)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.cert, true
+}
+
+func (c *leafCache) put(host string, cert *tls.Certificate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[host]; ok {
+		c.order.Remove(element)
+	}
+	c.entries[host] = c.order.PushFront(&leafEntry{host: host, cert: cert})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*leafEntry).host)
+	}
+}
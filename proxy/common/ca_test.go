@@ -0,0 +1,160 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testLeaf 只用于缓存测试的证书, 只需要有效期
+func testLeaf(validity time.Duration) *tls.Certificate {
+	return &tls.Certificate{Leaf: &x509.Certificate{NotAfter: time.Now().Add(validity)}}
+}
+
+func TestLeafCacheEviction(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		// access 依次获取的主机名
+		access []string
+		// cached 最后仍在缓存中的主机名
+		cached []string
+		// evicted 最后已被淘汰的主机名
+		evicted []string
+	}{
+		{"within capacity", 3, []string{"a", "b", "c"}, []string{"a", "b", "c"}, nil},
+		{"evict oldest", 2, []string{"a", "b", "c"}, []string{"b", "c"}, []string{"a"}},
+		{"hit refreshes", 2, []string{"a", "b", "a", "c"}, []string{"a", "c"}, []string{"b"}},
+		{"capacity one", 1, []string{"a", "b"}, []string{"b"}, []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newLeafCache(tt.capacity)
+			for _, host := range tt.access {
+				if _, e := cache.getOrCreate(host, func() (*tls.Certificate, error) { return testLeaf(24 * time.Hour), nil }); e != nil {
+					t.Fatal(e)
+				}
+			}
+			for _, host := range tt.cached {
+				if _, ok := cache.get(host); !ok {
+					t.Fatalf("%s evicted", host)
+				}
+			}
+			for _, host := range tt.evicted {
+				if _, ok := cache.entries[host]; ok {
+					t.Fatalf("%s still cached", host)
+				}
+			}
+			if cache.order.Len() > tt.capacity || len(cache.entries) != cache.order.Len() {
+				t.Fatalf("cache size %d/%d, capacity %d", len(cache.entries), cache.order.Len(), tt.capacity)
+			}
+		})
+	}
+}
+
+func TestLeafCacheExpiring(t *testing.T) {
+	cache := newLeafCache(2)
+	issued := 0
+	create := func() (*tls.Certificate, error) {
+		issued++
+		// 距离过期不足一小时的证书需要重新签发
+		return testLeaf(30 * time.Minute), nil
+	}
+	first, _ := cache.getOrCreate("a", create)
+	second, _ := cache.getOrCreate("a", create)
+	if issued != 2 || first == second {
+		t.Fatalf("expiring certificate reused, issued %d", issued)
+	}
+}
+
+func TestLeafCacheSingleIssue(t *testing.T) {
+	cache := newLeafCache(8)
+	var issued atomic.Int32
+	release := make(chan struct{})
+	create := func() (*tls.Certificate, error) {
+		issued.Add(1)
+		<-release
+		return testLeaf(24 * time.Hour), nil
+	}
+
+	var wg sync.WaitGroup
+	certs := make([]*tls.Certificate, 8)
+	for i := range certs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			certs[i], _ = cache.getOrCreate("a", create)
+		}(i)
+	}
+	// 其他主机名的签发不等待正在进行的签发
+	done := make(chan struct{})
+	go func() {
+		_, _ = cache.getOrCreate("b", func() (*tls.Certificate, error) { return testLeaf(24 * time.Hour), nil })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("issuing b blocked by a")
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if issued.Load() != 1 {
+		t.Fatalf("issued %d times for one host", issued.Load())
+	}
+	for _, cert := range certs {
+		if cert != certs[0] {
+			t.Fatal("concurrent handshakes got different certificates")
+		}
+	}
+}
+
+func TestCertificateAuthorityLeaf(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	ca, e := LoadOrCreateCA(certPath, keyPath, 4)
+	if e != nil {
+		t.Fatal(e)
+	}
+	// 再次加载使用同一个根证书
+	loaded, e := LoadOrCreateCA(certPath, keyPath, 4)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if loaded.Fingerprint() != ca.Fingerprint() {
+		t.Fatal("reloaded ca differs")
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := []struct {
+		host   string
+		verify string
+	}{
+		{"example.com", "example.com"},
+		{"Example.COM.", "example.com"},
+		{"example.com:443", "example.com"},
+		{"127.0.0.1", "127.0.0.1"},
+		{"[::1]:443", "::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			cert, e := loaded.leaf(tt.host)
+			if e != nil {
+				t.Fatal(e)
+			}
+			if _, e := cert.Leaf.Verify(x509.VerifyOptions{DNSName: tt.verify, Roots: roots}); e != nil {
+				t.Fatal(e)
+			}
+		})
+	}
+	if _, e := loaded.leaf(""); e == nil {
+		t.Fatal("issued certificate for empty host")
+	}
+}
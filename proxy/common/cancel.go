@@ -0,0 +1,81 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// bodyDoneReader 请求体读完或关闭时通知
+type bodyDoneReader struct {
+	io.ReadCloser
+	done chan struct{}
+	once sync.Once
+}
+
+func (r *bodyDoneReader) Read(p []byte) (int, error) {
+	n, e := r.ReadCloser.Read(p)
+	if e != nil {
+		r.finish()
+	}
+	return n, e
+}
+
+// Close 关闭时会读完剩余的请求体, 之后才能通知
+func (r *bodyDoneReader) Close() error {
+	e := r.ReadCloser.Close()
+	r.finish()
+	return e
+}
+
+func (r *bodyDoneReader) finish() {
+	r.once.Do(func() {
+		close(r.done)
+	})
+}
+
+// watchClientClose 请求体读完后在后台预读客户端连接, 客户端断开时取消请求的ctx, 边缘节点随之中止请求.
+// 使用Peek预读, 客户端提前发送的下一个请求仍留在reader中.
+// 返回的stop在写完响应后调用, 等待后台预读结束并返回请求体是否已经读完
+func watchClientClose(conn net.Conn, reader *bufio.Reader, request *http.Request) (*http.Request, func() bool) {
+	ctx, cancel := context.WithCancel(request.Context())
+	bodyDone := make(chan struct{})
+	if request.Body != nil && request.Body != http.NoBody {
+		request.Body = &bodyDoneReader{ReadCloser: request.Body, done: bodyDone}
+	} else {
+		close(bodyDone)
+	}
+
+	stopped := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-bodyDone:
+		case <-stopped:
+			return
+		}
+		if _, e := reader.Peek(1); e != nil {
+			cancel()
+		}
+	}()
+	stop := func() bool {
+		close(stopped)
+		// 唤醒后台预读, 之后才能读取下一个请求
+		_ = conn.SetReadDeadline(time.Now())
+		<-exited
+		_ = conn.SetReadDeadline(time.Time{})
+		cancel()
+		select {
+		case <-bodyDone:
+			return true
+		default:
+			return false
+		}
+	}
+	return request.WithContext(ctx), stop
+}
@@ -0,0 +1,223 @@
+package common
+
+import (
+	"asyncProxy/constant"
+	"asyncProxy/ws/edge"
+	"asyncProxy/ws/transport"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AffinitySource 会话键的来源, 同一会话的请求固定走同一个边缘节点
+type AffinitySource string
+
+const (
+	AffinityNone AffinitySource = "none"
+	// AffinityClientIp 按客户端IP固定
+	AffinityClientIp AffinitySource = "client_ip"
+	// AffinityHeader 按X-Async-Session请求头固定, 隧道和UDP没有请求头不固定
+	AffinityHeader AffinitySource = "header"
+	// AffinityUsername 按代理用户名中的session参数固定, 如 alice;session=abc
+	AffinityUsername AffinitySource = "username"
+)
+
+// ParseAffinitySource 解析配置中的会话来源, 为空时不固定
+func ParseAffinitySource(name string) (AffinitySource, error) {
+	switch source := AffinitySource(name); source {
+	case "", AffinityNone:
+		return AffinityNone, nil
+	case AffinityClientIp, AffinityHeader, AffinityUsername:
+		return source, nil
+	default:
+		return "", fmt.Errorf("unknown affinity source: %s", name)
+	}
+}
+
+const (
+	// DefaultIdleTimeout 连接等待下一个请求的默认时间
+	DefaultIdleTimeout = 60 * time.Second
+	// DefaultRequestTimeout 单个请求等待响应头和响应体没有进展的默认时间上限
+	DefaultRequestTimeout = 60 * time.Second
+)
+
+// ProxyOptions http和socks5代理监听端口的选项
+type ProxyOptions struct {
+	Tunnel   *TunnelPolicy
+	Affinity AffinitySource
+	// Retry HTTP请求失败后的重试策略, 为nil时不重试
+	Retry *edge.RetryPolicy
+	// UdpIdleTimeout socks5 UDP关联的空闲超时
+	UdpIdleTimeout time.Duration
+	// CA 中间人解密HTTPS时签发证书的根证书
+	CA *CertificateAuthority
+	// IdleTimeout 连接等待下一个请求(含TLS握手)的时间, 为0时使用DefaultIdleTimeout
+	IdleTimeout time.Duration
+	// RequestTimeout 单个请求等待响应头的时间上限, 也是响应体没有进展的时间上限, 为0时使用DefaultRequestTimeout
+	RequestTimeout time.Duration
+	// Users 代理用户, 为nil或既没有用户也没有用户文件时不需要认证
+	Users *UserStore
+}
+
+func (o *ProxyOptions) idleTimeout() time.Duration {
+	if o == nil || o.IdleTimeout <= 0 {
+		return DefaultIdleTimeout
+	}
+	return o.IdleTimeout
+}
+
+func (o *ProxyOptions) requestTimeout() time.Duration {
+	if o == nil || o.RequestTimeout <= 0 {
+		return DefaultRequestTimeout
+	}
+	return o.RequestTimeout
+}
+
+// ClientInfo 代理客户端的信息, 决定请求分发到哪个边缘节点
+type ClientInfo struct {
+	RemoteAddr net.Addr
+	// Username 代理用户名, 不含参数. 配置了代理用户时为认证通过的用户
+	Username string
+	// Params 代理用户名中以;分隔的参数, session以外的参数作为节点标签
+	Params  map[string]string
+	Options *ProxyOptions
+}
+
+// NewClientInfo rawUsername为客户端提供的完整用户名, 未认证时为空
+func NewClientInfo(remoteAddr net.Addr, rawUsername string, options *ProxyOptions) *ClientInfo {
+	username, params := ParseUsername(rawUsername)
+	return &ClientInfo{
+		RemoteAddr: remoteAddr,
+		Username:   username,
+		Params:     params,
+		Options:    options,
+	}
+}
+
+// String 用于日志, 如 alice@127.0.0.1:50000
+func (c *ClientInfo) String() string {
+	addr := ""
+	if c.RemoteAddr != nil {
+		addr = c.RemoteAddr.String()
+	}
+	if c.Username == "" {
+		return addr
+	}
+	return c.Username + "@" + addr
+}
+
+// reservedParams 代理用户名中有特殊含义的参数, 不作为节点标签
+var reservedParams = map[string]bool{
+	"session":  true,
+	"priority": true,
+}
+
+// ParseUsername 解析形如 alice;session=abc;key=value 的代理用户名
+func ParseUsername(raw string) (string, map[string]string) {
+	parts := strings.Split(raw, ";")
+	params := map[string]string{}
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(part, "=")
+		if key = strings.TrimSpace(key); key != "" {
+			params[key] = strings.TrimSpace(value)
+		}
+	}
+	return parts[0], params
+}
+
+// ProxyCredentials 取出Proxy-Authorization中Basic认证的用户名和密码
+func ProxyCredentials(header http.Header) (username, password string, ok bool) {
+	scheme, credentials, ok := strings.Cut(header.Get("Proxy-Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	decoded, e := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if e != nil {
+		return "", "", false
+	}
+	username, password, _ = strings.Cut(string(decoded), ":")
+	return username, password, true
+}
+
+// DispatchOptions 生成分发选项, header为nil表示没有请求头(隧道/UDP)
+func (c *ClientInfo) DispatchOptions(header http.Header) *edge.DispatchOptions {
+	if c == nil {
+		return nil
+	}
+	return &edge.DispatchOptions{
+		SessionKey: c.sessionKey(header),
+		Labels:     c.labels(header),
+		Priority:   c.priority(header),
+	}
+}
+
+// priority 排队优先级, 请求头优先于用户名参数, 无法解析时为normal
+func (c *ClientInfo) priority(header http.Header) edge.Priority {
+	value := header.Get(constant.HeaderAsyncPriority)
+	if value == "" {
+		value = c.Params["priority"]
+	}
+	priority, _ := edge.ParsePriority(value)
+	return priority
+}
+
+// labels 节点标签选择, 请求头中的标签优先于用户名参数
+func (c *ClientInfo) labels(header http.Header) map[string]string {
+	if value := header.Get(constant.HeaderAsyncLabels); value != "" {
+		return transport.ParseLabels(value)
+	}
+	labels := map[string]string{}
+	for key, value := range c.Params {
+		if !reservedParams[key] {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// RetryPolicy 该客户端所在监听端口的重试策略
+func (c *ClientInfo) RetryPolicy() *edge.RetryPolicy {
+	if c == nil || c.Options == nil {
+		return nil
+	}
+	return c.Options.Retry
+}
+
+func (c *ClientInfo) sessionKey(header http.Header) string {
+	if c.Options == nil {
+		return ""
+	}
+	switch c.Options.Affinity {
+	case AffinityClientIp:
+		if c.RemoteAddr == nil {
+			return ""
+		}
+		host, _, e := net.SplitHostPort(c.RemoteAddr.String())
+		if e != nil {
+			return c.RemoteAddr.String()
+		}
+		return "ip/" + host
+	case AffinityHeader:
+		if value := header.Get(constant.HeaderAsyncSession); value != "" {
+			return "header/" + value
+		}
+	case AffinityUsername:
+		if value := c.Params["session"]; value != "" {
+			// 不同用户的会话互不影响
+			return "user/" + c.Username + "/" + value
+		}
+	}
+	return ""
+}
+
+// removeProxyHeaders 移除只对代理有意义的请求头, 不转发给目标
+func removeProxyHeaders(header http.Header) {
+	header.Del("Proxy-Authorization")
+	header.Del("Proxy-Connection")
+	header.Del(constant.HeaderAsyncSession)
+	header.Del(constant.HeaderAsyncLabels)
+	header.Del(constant.HeaderAsyncPriority)
+}
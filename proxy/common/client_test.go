@@ -0,0 +1,57 @@
+package common
+
+import (
+	"encoding/base64"
+	"maps"
+	"net/http"
+	"testing"
+)
+
+func TestParseUsername(t *testing.T) {
+	tests := []struct {
+		raw        string
+		wantName   string
+		wantParams map[string]string
+	}{
+		{"alice", "alice", map[string]string{}},
+		{"alice;session=abc", "alice", map[string]string{"session": "abc"}},
+		{"alice; session = abc ;region=cn-east", "alice", map[string]string{"session": "abc", "region": "cn-east"}},
+		{"alice;flag;=ignored;", "alice", map[string]string{"flag": ""}},
+		{"", "", map[string]string{}},
+	}
+	for _, tt := range tests {
+		name, params := ParseUsername(tt.raw)
+		if name != tt.wantName || !maps.Equal(params, tt.wantParams) {
+			t.Fatalf("ParseUsername(%q) = %q, %v, want %q, %v", tt.raw, name, params, tt.wantName, tt.wantParams)
+		}
+	}
+}
+
+func TestProxyCredentials(t *testing.T) {
+	tests := []struct {
+		name         string
+		header       string
+		wantUsername string
+		wantPassword string
+		wantOk       bool
+	}{
+		{"basic", "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:secret")), "alice", "secret", true},
+		{"scheme case insensitive", "basic " + base64.StdEncoding.EncodeToString([]byte("alice;session=1:a:b")), "alice;session=1", "a:b", true},
+		{"no password", "Basic " + base64.StdEncoding.EncodeToString([]byte("alice")), "alice", "", true},
+		{"missing", "", "", "", false},
+		{"other scheme", "Bearer token", "", "", false},
+		{"invalid base64", "Basic !!!", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.header != "" {
+				header.Set("Proxy-Authorization", tt.header)
+			}
+			username, password, ok := ProxyCredentials(header)
+			if username != tt.wantUsername || password != tt.wantPassword || ok != tt.wantOk {
+				t.Fatalf("got %q, %q, %v", username, password, ok)
+			}
+		})
+	}
+}
@@ -1,134 +1,66 @@
 package common
 
 import (
+	"asyncProxy/constant"
 	"asyncProxy/errors"
 	"asyncProxy/util"
 	"asyncProxy/ws"
+	"asyncProxy/ws/edge"
+	"asyncProxy/ws/transport"
 	"bufio"
 	"bytes"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
+	"context"
+	"crypto/tls"
 	goerrors "errors"
 	"fmt"
 	"golang.org/x/net/http2"
 	"io"
 	"log"
-	"math/big"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"slices"
 	"strings"
-	"sync"
 	"time"
 )
 
-var fakeCertKey []byte
-var fakeCertCert []byte
-var certMux = sync.Mutex{}
-
-func GenerateFakeCert() (cert []byte, key []byte, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			cert = nil
-			key = nil
-			if r, ok := r.(error); ok {
-				err = r
-			} else {
-				err = goerrors.New("unknown error")
-			}
-		}
-	}()
-	certMux.Lock()
-	defer certMux.Unlock()
-	if fakeCertCert != nil && fakeCertKey != nil {
-		return fakeCertCert, fakeCertKey, nil
-	}
-
-	log.Println("start generate cert")
-	pk, err := rsa.GenerateKey(rand.Reader, 4096)
-	util.OkOrPanic(err)
-	tpl := x509.Certificate{
-		SerialNumber:          big.NewInt(1),
-		Subject:               pkix.Name{CommonName: "127.0.0.1"},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(2, 0, 0),
-		BasicConstraintsValid: true,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
-	}
-	c, err := x509.CreateCertificate(rand.Reader, &tpl, &tpl, &pk.PublicKey, pk)
-	util.OkOrPanic(err)
-
-	certBuf := bytes.NewBuffer(nil)
-	e := pem.Encode(certBuf, &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: c,
-	})
-	util.OkOrPanic(e)
-
-	keyBuf := bytes.NewBuffer(nil)
-	e = pem.Encode(keyBuf, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(pk),
-	})
-	util.OkOrPanic(e)
-
-	log.Println("generate cert complete")
-	fakeCertCert = certBuf.Bytes()
-	fakeCertKey = keyBuf.Bytes()
-	return fakeCertCert, fakeCertKey, nil
-}
-
 type ProxyHttp2Handler struct {
 	OriginUrl  *url.URL
 	OriginPort string
+	Client     *ClientInfo
 }
 
 func (h ProxyHttp2Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	if request.URL.Host == "" && h.OriginUrl.Host != "" {
 		request.URL.Host = h.OriginUrl.Host
 	}
-	response, e := processHttp11Request(request, h.OriginPort)
+	timeout := h.Client.Options.requestTimeout()
+	ctx, cancel := context.WithTimeout(request.Context(), timeout)
+	response, e := processHttp11Request(request.WithContext(ctx), h.OriginPort, h.Client)
+	if e != nil && headerTimedOut(ctx) {
+		e = errors.NewBusinessError(http.StatusGatewayTimeout, "等待边缘节点响应超时")
+	}
+	cancel()
 	if e != nil {
 		response = convertErrorToResponse(request, e)
 	}
+	// 收到响应头后不再限制总时长, 响应体超过timeout没有进展时中止
+	body := transport.NewStallReader(response.Body, timeout)
+	defer body.Close()
 	for key, value := range response.Header {
 		for _, val := range value {
 			writer.Header().Add(key, val)
 		}
 	}
 	writer.WriteHeader(response.StatusCode)
-	content, e := readRequestBody(response.Body)
-	if e != nil {
-		log.Println("read response body error:", e)
-	}
-	_, e = writer.Write(content)
+	controller := http.NewResponseController(writer)
+	_, e = io.Copy(deadlineWriter{w: writer, setDeadline: controller.SetWriteDeadline, timeout: timeout}, body)
 	if e != nil {
 		log.Println("http2 write error:", e)
 	}
 }
 
-func readRequestBody(reader io.Reader) (content []byte, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			content = nil
-			if r, ok := r.(error); ok {
-				err = r
-			} else {
-				err = goerrors.New("unknown error")
-			}
-		}
-	}()
-
-	c, e := io.ReadAll(reader)
-	util.OkOrPanic(e)
-	content = c
-	return
-}
-
 func convertErrorToResponse(request *http.Request, e error) *http.Response {
 	response := &http.Response{
 		Header:     map[string][]string{},
@@ -137,6 +69,7 @@ func convertErrorToResponse(request *http.Request, e error) *http.Response {
 		ProtoMinor: request.ProtoMinor,
 	}
 	response.Header.Add("x-proxy-error", "1")
+	var body []byte
 	switch ev := e.(type) {
 	case *errors.BusinessError:
 		code := 0
@@ -147,16 +80,18 @@ func convertErrorToResponse(request *http.Request, e error) *http.Response {
 		}
 		response.StatusCode = code
 		response.Status = "RequestFailed"
-		response.Body = io.NopCloser(bytes.NewReader([]byte(ev.Message)))
+		body = []byte(ev.Message)
 	default:
 		response.StatusCode = 500
 		response.Status = "InternalServerError"
-		response.Body = io.NopCloser(bytes.NewReader([]byte(fmt.Sprintln("内部错误:", ev.Error()))))
+		body = []byte(fmt.Sprintln("内部错误:", ev.Error()))
 	}
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	response.ContentLength = int64(len(body))
 	return response
 }
 
-func processHttp11Request(request *http.Request, port string) (response *http.Response, err error) {
+func processHttp11Request(request *http.Request, port string, client *ClientInfo) (response *http.Response, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			response = nil
@@ -167,6 +102,9 @@ func processHttp11Request(request *http.Request, port string) (response *http.Re
 			}
 		}
 	}()
+	if ca := client.Options.CA; ca != nil && (IsCAHost(request.URL.Host) || request.URL.Host == "" && IsCAHost(request.Host)) {
+		return ca.caResponse(request), nil
+	}
 	actualUrl := ""
 
 	if request.URL.IsAbs() {
@@ -209,67 +147,160 @@ func processHttp11Request(request *http.Request, port string) (response *http.Re
 		actualUrl = actualUrl + "?" + request.URL.RawQuery
 	}
 
-	reqBody, e := readRequestBody(request.Body)
-	util.OkOrPanic(e)
+	var reqBody io.Reader
+	if request.Body != nil && request.Body != http.NoBody && request.ContentLength != 0 {
+		reqBody = request.Body
+	}
+	policy := client.RetryPolicy()
 
-	wsResponse, err := ws.SendRequestAndWait(request.Method, actualUrl,
-		request.Header, reqBody, 30*time.Second)
+	options := client.DispatchOptions(request.Header)
+	removeProxyHeaders(request.Header)
+	wsResponse, attempts, err := ws.SendRequestWithRetry(request.Context(), request.Method, actualUrl,
+		request.Header, reqBody, policy, options)
 	util.OkOrPanic(err)
 
 	if !wsResponse.Success {
-		errors.NewBusinessError(503, fmt.Sprint("边缘节点返回错误信息:", wsResponse.ErrorMessage)).Panic()
+		resp := convertErrorToResponse(request,
+			errors.NewBusinessError(503, fmt.Sprint("边缘节点返回错误信息:", wsResponse.ErrorMessage)))
+		if policy.Enabled(request.Method) {
+			resp.Header.Set(constant.HeaderAsyncAttempts, edge.FormatAttempts(attempts))
+		}
+		return resp, nil
 	}
 
 	resp := &http.Response{
-		Header:     wsResponse.Headers,
-		StatusCode: wsResponse.StatusCode,
-		Request:    request,
-		Body:       io.NopCloser(bytes.NewReader(wsResponse.Body)),
-		ProtoMajor: request.ProtoMajor,
-		ProtoMinor: request.ProtoMinor,
+		Header:        wsResponse.Headers,
+		StatusCode:    wsResponse.StatusCode,
+		Request:       request,
+		Body:          wsResponse.BodyReader,
+		ContentLength: wsResponse.ContentLength,
+		ProtoMajor:    request.ProtoMajor,
+		ProtoMinor:    request.ProtoMinor,
+	}
+	if policy.Enabled(request.Method) {
+		if resp.Header == nil {
+			resp.Header = http.Header{}
+		}
+		resp.Header.Set(constant.HeaderAsyncAttempts, edge.FormatAttempts(attempts))
+	}
+	if resp.ContentLength < 0 && resp.ProtoAtLeast(1, 1) {
+		// 长度未知的响应体以chunked方式边收边写
+		resp.TransferEncoding = []string{"chunked"}
 	}
 
 	return resp, nil
 }
 
-func ProcessHttp2ProxyRequest(netConn net.Conn, originUrl *url.URL, originPort string) {
+func ProcessHttp2ProxyRequest(netConn net.Conn, originUrl *url.URL, originPort string, client *ClientInfo) {
 	h := ProxyHttp2Handler{
 		OriginUrl:  originUrl,
 		OriginPort: originPort,
+		Client:     client,
 	}
-	h2s := http2.Server{}
+	h2s := http2.Server{IdleTimeout: client.Options.idleTimeout()}
 	h2s.ServeConn(netConn, &http2.ServeConnOpts{Handler: h, SawClientPreface: false, Settings: []byte{}})
 }
 
-func ProcessHttp11ProxyRequest(netConn net.Conn, request *http.Request, isConnect bool, port string) {
-	if isConnect {
-		h11Req, e := http.ReadRequest(bufio.NewReader(netConn))
-		if e != nil {
-			log.Println("read tls conn error:", e)
-			response := convertErrorToResponse(request, e)
-			e := response.Write(netConn)
+// ReadHttp11Request 读取连接上的下一个请求, 超过空闲时间没有读到请求头时返回错误
+func ReadHttp11Request(conn net.Conn, reader *bufio.Reader, options *ProxyOptions) (*http.Request, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(options.idleTimeout()))
+	request, e := http.ReadRequest(reader)
+	_ = conn.SetReadDeadline(time.Time{})
+	return request, e
+}
+
+// PeekFirstByte 预读连接的第一个字节, 用于判断协议, 超过空闲时间没有数据时返回错误
+func PeekFirstByte(conn net.Conn, reader *bufio.Reader, options *ProxyOptions) (byte, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(options.idleTimeout()))
+	b, e := reader.Peek(1)
+	_ = conn.SetReadDeadline(time.Time{})
+	if e != nil {
+		return 0, e
+	}
+	return b[0], nil
+}
+
+// HandshakeTLS 完成中间人解密的TLS握手, 握手时间不超过空闲时间
+func HandshakeTLS(conn net.Conn, host string, options *ProxyOptions) (*tls.Conn, error) {
+	tlsConn := tls.Server(conn, options.CA.TLSConfig(host))
+	_ = conn.SetDeadline(time.Now().Add(options.idleTimeout()))
+	e := tlsConn.Handshake()
+	_ = conn.SetDeadline(time.Time{})
+	return tlsConn, e
+}
+
+// ProcessHttp11ProxyRequest 处理连接上的HTTP/1.1请求, 客户端保持连接时继续处理下一个请求.
+// request为已经读出的第一个请求, 为nil时从reader读取; originHost为CONNECT的目标地址, 请求没有指定主机时使用
+func ProcessHttp11ProxyRequest(conn net.Conn, reader *bufio.Reader, request *http.Request, originHost, port string, client *ClientInfo) {
+	for {
+		if request == nil {
+			var e error
+			request, e = ReadHttp11Request(conn, reader, client.Options)
 			if e != nil {
-				log.Println("write to tls conn error:", e)
+				if !connectionClosed(e) {
+					log.Println("read http request error:", e)
+				}
+				return
 			}
-			return
 		}
-		if h11Req.URL.Host == "" && request.URL.Host != "" {
-			h11Req.URL.Host = request.URL.Host
+		if request.URL.Host == "" && originHost != "" {
+			request.URL.Host = originHost
 		}
-		request = h11Req
+		// 解密后的请求按https发送到目标
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			request.TLS = &state
+		}
+		if !ServeHttp11Request(conn, reader, request, port, client) {
+			return
+		}
+		request = nil
 	}
+}
 
-	response, e := processHttp11Request(request, port)
+// ServeHttp11Request 处理一个请求并写回响应, 返回连接能否继续处理下一个请求.
+// 超过请求处理时间上限没有收到响应头时返回504, 之后响应体超过该时间没有进展时关闭连接
+func ServeHttp11Request(conn net.Conn, reader *bufio.Reader, request *http.Request, port string, client *ClientInfo) bool {
+	timeout := client.Options.requestTimeout()
+	request, stopWatch := watchClientClose(conn, reader, request)
+	ctx, cancel := context.WithTimeout(request.Context(), timeout)
+	response, e := processHttp11Request(request.WithContext(ctx), port, client)
+	cancel()
+	if e != nil && request.Context().Err() != nil {
+		stopWatch()
+		// 客户端已断开, 请求已在边缘节点取消
+		return false
+	}
+	if e != nil && headerTimedOut(ctx) {
+		log.Println("request process timeout:", request.Host, client)
+		e = errors.NewBusinessError(http.StatusGatewayTimeout, "等待边缘节点响应超时")
+	}
 	if e != nil {
-		errorResponse := convertErrorToResponse(request, e)
-		e := errorResponse.Write(netConn)
-		if e != nil {
-			log.Println("write error response to net.Conn error:", e)
-		}
-		return
+		response = convertErrorToResponse(request, e)
 	}
-	e = response.Write(netConn)
+	response.Body = transport.NewStallReader(response.Body, timeout)
+	defer response.Body.Close()
+
+	// 长度未知又不是chunked的响应体只能以关闭连接结束
+	keepAlive := !request.Close && (response.ContentLength >= 0 || slices.Contains(response.TransferEncoding, "chunked"))
+	if response.Header != nil {
+		response.Header.Del("Connection")
+		response.Header.Del("Keep-Alive")
+	}
+	response.Close = !keepAlive
+	e = response.Write(deadlineWriter{w: conn, setDeadline: conn.SetWriteDeadline, timeout: timeout})
+	_ = conn.SetWriteDeadline(time.Time{})
+	bodyDone := stopWatch()
 	if e != nil {
 		log.Println("write to net.Conn error:", e)
+		return false
 	}
+	// 请求体没有读完时无法读取下一个请求
+	return keepAlive && bodyDone
+}
+
+// connectionClosed 客户端关闭连接或空闲超时, 不需要记录日志
+func connectionClosed(e error) bool {
+	return goerrors.Is(e, io.EOF) || goerrors.Is(e, io.ErrUnexpectedEOF) || goerrors.Is(e, os.ErrDeadlineExceeded) ||
+		goerrors.Is(e, net.ErrClosed)
 }
@@ -0,0 +1,110 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// regexPrefix 以该前缀开头的规则按正则表达式匹配主机名
+const regexPrefix = "regex:"
+
+// HostMatcher 按主机名匹配目标地址, 支持精确匹配、*.example.com形式的通配、
+// regex:开头的正则表达式(完整匹配不含端口的小写主机名, 不需要写^和$)和10.0.0.0/8形式的CIDR(匹配IP目标).
+// 精确匹配和通配规则可以带端口(example.com:22), 不带端口时匹配所有端口
+type HostMatcher struct {
+	rules []hostRule
+}
+
+type hostRule struct {
+	// pattern 配置中的原始规则
+	pattern  string
+	host     string
+	wildcard bool
+	port     string
+	regexp   *regexp.Regexp
+	network  *net.IPNet
+}
+
+func NewHostMatcher(patterns []string) (*HostMatcher, error) {
+	m := &HostMatcher{}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		rule := hostRule{pattern: pattern}
+		if expr, ok := strings.CutPrefix(pattern, regexPrefix); ok {
+			// 正则表达式必须匹配整个主机名, 否则 regex:example\.com 也会命中 example.com.attacker.net
+			re, e := regexp.Compile("^(?:" + expr + ")$")
+			if e != nil {
+				return nil, fmt.Errorf("invalid host pattern %s: %w", pattern, e)
+			}
+			rule.regexp = re
+			m.rules = append(m.rules, rule)
+			continue
+		}
+		if _, network, e := net.ParseCIDR(pattern); e == nil {
+			rule.network = network
+			m.rules = append(m.rules, rule)
+			continue
+		}
+		rule.host = strings.ToLower(pattern)
+		if host, port, e := net.SplitHostPort(rule.host); e == nil {
+			rule.host = host
+			rule.port = port
+		}
+		if strings.HasPrefix(rule.host, "*.") {
+			rule.wildcard = true
+			rule.host = rule.host[1:]
+		}
+		m.rules = append(m.rules, rule)
+	}
+	return m, nil
+}
+
+// Match 判断目标地址是否命中规则, address可以是host或host:port
+func (m *HostMatcher) Match(address string) bool {
+	_, ok := m.MatchRule(address)
+	return ok
+}
+
+// MatchRule 返回目标地址命中的原始规则
+func (m *HostMatcher) MatchRule(address string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	host, port := splitAddress(address)
+	ip := net.ParseIP(host)
+	for _, rule := range m.rules {
+		if rule.match(host, port, ip) {
+			return rule.pattern, true
+		}
+	}
+	return "", false
+}
+
+func (r *hostRule) match(host, port string, ip net.IP) bool {
+	switch {
+	case r.regexp != nil:
+		return r.regexp.MatchString(host)
+	case r.network != nil:
+		return ip != nil && r.network.Contains(ip)
+	case r.port != "" && r.port != port:
+		return false
+	case r.wildcard:
+		return strings.HasSuffix(host, r.host)
+	default:
+		return host == r.host
+	}
+}
+
+func splitAddress(address string) (host, port string) {
+	host, port, e := net.SplitHostPort(address)
+	if e != nil {
+		host = address
+		port = ""
+	}
+	return strings.ToLower(strings.Trim(host, "[]")), port
+}
@@ -0,0 +1,75 @@
+package common
+
+import "testing"
+
+func TestHostMatcher(t *testing.T) {
+	matcher, e := NewHostMatcher([]string{
+		"example.com",
+		"*.wild.com",
+		"ssh.host.com:22",
+		"*.port.com:8443",
+		`regex:^api\d+\.re\.com$`,
+		`regex:exact\.com|pay\..+`,
+		"10.0.0.0/8",
+		"fd00::/8",
+		"  ",
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	tests := []struct {
+		address string
+		want    string
+		match   bool
+	}{
+		{"example.com", "example.com", true},
+		{"EXAMPLE.com:443", "example.com", true},
+		{"www.example.com", "", false},
+		{"a.wild.com", "*.wild.com", true},
+		{"a.b.wild.com:80", "*.wild.com", true},
+		{"wild.com", "", false},
+		{"ssh.host.com:22", "ssh.host.com:22", true},
+		{"ssh.host.com:443", "", false},
+		{"ssh.host.com", "", false},
+		{"a.port.com:8443", "*.port.com:8443", true},
+		{"a.port.com:443", "", false},
+		{"api1.re.com:443", `regex:^api\d+\.re\.com$`, true},
+		{"API22.RE.COM", `regex:^api\d+\.re\.com$`, true},
+		{"api.re.com", "", false},
+		{"exact.com", `regex:exact\.com|pay\..+`, true},
+		{"pay.example.net:443", `regex:exact\.com|pay\..+`, true},
+		// 正则表达式完整匹配主机名, 不匹配包含它的主机名
+		{"exact.com.attacker.net", "", false},
+		{"www.exact.com", "", false},
+		{"repay.example.net", "", false},
+		{"10.1.2.3:80", "10.0.0.0/8", true},
+		{"11.1.2.3", "", false},
+		{"[fd00::1]:443", "fd00::/8", true},
+		{"10.example.com", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			got, ok := matcher.MatchRule(tt.address)
+			if ok != tt.match || got != tt.want {
+				t.Fatalf("MatchRule(%q) = %q, %v, want %q, %v", tt.address, got, ok, tt.want, tt.match)
+			}
+			if matcher.Match(tt.address) != tt.match {
+				t.Fatalf("Match(%q) != %v", tt.address, tt.match)
+			}
+		})
+	}
+}
+
+func TestHostMatcherInvalid(t *testing.T) {
+	if _, e := NewHostMatcher([]string{"example.com", "regex:("}); e == nil {
+		t.Fatal("invalid regex accepted")
+	}
+	var matcher *HostMatcher
+	if matcher.Match("example.com") {
+		t.Fatal("nil matcher matched")
+	}
+	empty, e := NewHostMatcher(nil)
+	if e != nil || empty.Match("example.com") {
+		t.Fatalf("empty matcher: %v", e)
+	}
+}
@@ -0,0 +1,176 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// CAHost 通过代理访问该主机名时由代理直接返回根证书安装页面, 不转发到边缘节点
+const CAHost = "ca.asyncproxy"
+
+// 根证书的导出格式
+const (
+	CAFormatPem = "pem"
+	// CAFormatDer Android、Windows安装使用
+	CAFormatDer = "der"
+	// CAFormatMobileConfig iOS、macOS描述文件
+	CAFormatMobileConfig = "mobileconfig"
+)
+
+// CAFile 导出的根证书文件
+type CAFile struct {
+	Name        string
+	ContentType string
+	Content     []byte
+}
+
+//go:embed install.html
+var installHtml string
+
+var installTemplate = template.Must(template.New("install").Delims("[[", "]]").Parse(installHtml))
+
+// Export 按格式导出根证书
+func (ca *CertificateAuthority) Export(format string) (*CAFile, error) {
+	switch format {
+	case CAFormatPem:
+		return &CAFile{Name: "ca.pem", ContentType: "application/x-pem-file", Content: ca.certPem}, nil
+	case CAFormatDer:
+		return &CAFile{Name: "ca.crt", ContentType: "application/x-x509-ca-cert", Content: ca.cert.Raw}, nil
+	case CAFormatMobileConfig:
+		return &CAFile{Name: "ca.mobileconfig", ContentType: "application/x-apple-aspen-config", Content: ca.mobileConfig()}, nil
+	default:
+		return nil, fmt.Errorf("unknown ca format: %s", format)
+	}
+}
+
+// ExportFile 按文件名导出根证书, 文件名为Export返回的Name
+func (ca *CertificateAuthority) ExportFile(name string) (*CAFile, bool) {
+	for _, format := range []string{CAFormatPem, CAFormatDer, CAFormatMobileConfig} {
+		if file, _ := ca.Export(format); file.Name == name {
+			return file, true
+		}
+	}
+	return nil, false
+}
+
+// Fingerprint 根证书的SHA-256指纹, 用于安装后核对
+func (ca *CertificateAuthority) Fingerprint() string {
+	sum := sha256.Sum256(ca.cert.Raw)
+	hex := make([]string, 0, len(sum))
+	for _, b := range sum {
+		hex = append(hex, fmt.Sprintf("%02X", b))
+	}
+	return strings.Join(hex, ":")
+}
+
+// InstallPage 根证书下载和安装说明页面, prefix为下载链接的路径前缀
+func (ca *CertificateAuthority) InstallPage(prefix string) []byte {
+	buf := &bytes.Buffer{}
+	_ = installTemplate.Execute(buf, map[string]any{
+		"Name":        ca.cert.Subject.CommonName,
+		"Fingerprint": ca.Fingerprint(),
+		"NotAfter":    ca.cert.NotAfter.Format("2006-01-02"),
+		"Prefix":      strings.TrimSuffix(prefix, "/"),
+	})
+	return buf.Bytes()
+}
+
+// mobileConfig iOS、macOS安装根证书的描述文件, UUID由证书内容生成, 重复安装时替换旧的描述文件
+func (ca *CertificateAuthority) mobileConfig() []byte {
+	name := xmlEscape(ca.cert.Subject.CommonName)
+	payloadUuid := uuidOf(ca.cert.Raw, "payload")
+	profileUuid := uuidOf(ca.cert.Raw, "profile")
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>PayloadContent</key>
+	<array>
+		<dict>
+			<key>PayloadCertificateFileName</key>
+			<string>ca.crt</string>
+			<key>PayloadContent</key>
+			<data>%s</data>
+			<key>PayloadDisplayName</key>
+			<string>%s</string>
+			<key>PayloadIdentifier</key>
+			<string>com.apple.security.root.%s</string>
+			<key>PayloadType</key>
+			<string>com.apple.security.root</string>
+			<key>PayloadUUID</key>
+			<string>%s</string>
+			<key>PayloadVersion</key>
+			<integer>1</integer>
+		</dict>
+	</array>
+	<key>PayloadDisplayName</key>
+	<string>%s</string>
+	<key>PayloadIdentifier</key>
+	<string>asyncproxy.ca.%s</string>
+	<key>PayloadType</key>
+	<string>Configuration</string>
+	<key>PayloadUUID</key>
+	<string>%s</string>
+	<key>PayloadVersion</key>
+	<integer>1</integer>
+</dict>
+</plist>
+`, base64.StdEncoding.EncodeToString(ca.cert.Raw), name, payloadUuid, payloadUuid, name, profileUuid, profileUuid))
+}
+
+func xmlEscape(s string) string {
+	buf := &strings.Builder{}
+	_ = xml.EscapeText(buf, []byte(s))
+	return buf.String()
+}
+
+// uuidOf 由内容生成固定的UUID
+func uuidOf(content []byte, salt string) string {
+	sum := sha256.Sum256(append([]byte(salt), content...))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("%X-%X-%X-%X-%X", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// IsCAHost 判断目标地址是否为证书安装页面
+func IsCAHost(address string) bool {
+	if host, _, e := net.SplitHostPort(address); e == nil {
+		address = host
+	}
+	return strings.EqualFold(strings.TrimSuffix(address, "."), CAHost)
+}
+
+// caResponse 代理直接返回的证书安装页面和证书文件
+func (ca *CertificateAuthority) caResponse(request *http.Request) *http.Response {
+	response := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Request:    request,
+		ProtoMajor: request.ProtoMajor,
+		ProtoMinor: request.ProtoMinor,
+	}
+	var content []byte
+	if name := strings.TrimPrefix(request.URL.Path, "/"); name == "" {
+		content = ca.InstallPage("")
+		response.Header.Set("Content-Type", "text/html; charset=utf-8")
+	} else if file, ok := ca.ExportFile(name); ok {
+		content = file.Content
+		response.Header.Set("Content-Type", file.ContentType)
+		response.Header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Name))
+	} else {
+		content = []byte("not found")
+		response.StatusCode = http.StatusNotFound
+	}
+	response.ContentLength = int64(len(content))
+	response.Body = io.NopCloser(bytes.NewReader(content))
+	return response
+}
@@ -0,0 +1,25 @@
+package common
+
+import (
+	"context"
+	goerrors "errors"
+	"io"
+	"time"
+)
+
+// deadlineWriter 每次写入前重新设置写超时, 客户端超过timeout没有接收数据时写入失败, 不限制传输总时长
+type deadlineWriter struct {
+	w           io.Writer
+	setDeadline func(time.Time) error
+	timeout     time.Duration
+}
+
+func (w deadlineWriter) Write(p []byte) (int, error) {
+	_ = w.setDeadline(time.Now().Add(w.timeout))
+	return w.w.Write(p)
+}
+
+// headerTimedOut 等待响应头时是否超过了请求处理时间上限
+func headerTimedOut(ctx context.Context) bool {
+	return goerrors.Is(ctx.Err(), context.DeadlineExceeded)
+}
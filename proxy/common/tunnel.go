@@ -0,0 +1,74 @@
+package common
+
+import (
+	"asyncProxy/ws"
+	"asyncProxy/ws/transport"
+	"io"
+	"log"
+	"time"
+)
+
+const tunnelOpenTimeout = 30 * time.Second
+
+// TunnelPolicy 决定哪些目标不做TLS解密, 直接由边缘节点连接并原样转发字节
+type TunnelPolicy struct {
+	// All 该监听端口的所有连接都走隧道
+	All   bool
+	Hosts *HostMatcher
+	// Bypass 禁止中间人解密的目标, 如证书固定的应用和银行域名
+	Bypass *HostMatcher
+}
+
+func NewTunnelPolicy(all bool, hosts, bypass []string) (*TunnelPolicy, error) {
+	hostMatcher, e := NewHostMatcher(hosts)
+	if e != nil {
+		return nil, e
+	}
+	bypassMatcher, e := NewHostMatcher(bypass)
+	if e != nil {
+		return nil, e
+	}
+	return &TunnelPolicy{
+		All:    all,
+		Hosts:  hostMatcher,
+		Bypass: bypassMatcher,
+	}, nil
+}
+
+// Match 判断目标地址(host:port)是否需要走隧道, 返回的原因用于日志.
+// addresses为同一目标的不同写法, 如socks5客户端给出的域名和解析出的IP
+func (p *TunnelPolicy) Match(addresses ...string) (string, bool) {
+	if p == nil || len(addresses) == 0 {
+		return "", false
+	}
+	// 证书安装页面由代理直接返回, 需要解密
+	if IsCAHost(addresses[0]) {
+		return "", false
+	}
+	for _, address := range addresses {
+		if rule, ok := p.Bypass.MatchRule(address); ok {
+			return "bypass " + rule, true
+		}
+	}
+	if p.All {
+		return "all", true
+	}
+	for _, address := range addresses {
+		if rule, ok := p.Hosts.MatchRule(address); ok {
+			return "host " + rule, true
+		}
+	}
+	return "", false
+}
+
+// OpenTunnel 通过边缘节点连接目标地址
+func OpenTunnel(address string, client *ClientInfo) (*transport.Stream, error) {
+	return ws.OpenTunnel("tcp", address, tunnelOpenTimeout, client.DispatchOptions(nil))
+}
+
+// RelayTunnel 在客户端连接和隧道之间转发字节, reader用于读取客户端已缓冲的数据, reason为走隧道的原因
+func RelayTunnel(reader io.Reader, writer io.Writer, stream *transport.Stream, address, reason string, client *ClientInfo) {
+	log.Printf("tunnel opened: %s (%s) %s\n", address, reason, client)
+	transport.Relay(reader, writer, stream)
+	log.Println("tunnel closed:", address)
+}
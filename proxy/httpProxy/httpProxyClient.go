@@ -4,17 +4,18 @@ import (
 	"asyncProxy/proxy/common"
 	"asyncProxy/util"
 	"bufio"
-	"crypto/tls"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
-	"time"
+	"strings"
 )
 
 type HttpProxyClient struct {
-	Host string
-	Port uint16
+	Host    string
+	Port    uint16
+	Options *common.ProxyOptions
 }
 
 // Run 实现proxy的Run方法
@@ -23,21 +24,17 @@ func (p *HttpProxyClient) Run() {
 }
 
 // NewProxy 实现proxy的NewProxy方法
-func NewProxy(host string, port uint16) *HttpProxyClient {
+func NewProxy(host string, port uint16, options *common.ProxyOptions) *HttpProxyClient {
 	return &HttpProxyClient{
-		Host: host,
-		Port: port,
+		Host:    host,
+		Port:    port,
+		Options: options,
 	}
 }
 
 // Listen 监听客户端请求
 func (p *HttpProxyClient) Listen() {
-	cert, key, e := common.GenerateFakeCert()
-	util.OkOrPanic(e)
-
-	tlsCert, e := tls.X509KeyPair(cert, key)
-	util.OkOrPanic(e)
-	handler := httpProxyHandler{Cert: tlsCert}
+	handler := httpProxyHandler{Options: p.Options}
 
 	listener, e := net.Listen("tcp", fmt.Sprintf("%s:%d", p.Host, p.Port))
 	util.OkOrPanic(e)
@@ -46,62 +43,105 @@ func (p *HttpProxyClient) Listen() {
 	for {
 		conn, err := listener.Accept()
 		util.OkOrPanic(err)
-		go func() {
-			timer := time.After(1 * time.Minute)
-
-			done := make(chan bool)
-			go func() {
-				handler.ProcessTcpConnection(conn)
-				done <- true
-			}()
-			select {
-			case <-done:
-			case <-timer:
-				_ = conn.Close()
-				log.Println("request process timeout")
-			}
-		}()
+		// 空闲和请求处理超时由连接处理过程控制, 隧道建立后不受限制
+		go handler.ProcessTcpConnection(conn)
 	}
 }
 
 type httpProxyHandler struct {
-	Cert tls.Certificate
+	Options *common.ProxyOptions
 }
 
+// ProcessTcpConnection 处理代理连接, 普通HTTP请求可以在同一个连接上连续发送, 收到CONNECT后连接用于隧道或解密
 func (h httpProxyHandler) ProcessTcpConnection(conn net.Conn) {
 	defer conn.Close()
 	connReader := bufio.NewReader(conn)
-	request, e := http.ReadRequest(bufio.NewReader(connReader))
-	if e != nil {
-		log.Println("tcp connection is not a valid http request, aborting...")
-		return
-	}
-
-	if request.Method == http.MethodConnect {
-		_, e := fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	for {
+		request, e := common.ReadHttp11Request(conn, connReader, h.Options)
 		if e != nil {
-			log.Println("write error:", e)
 			return
 		}
-
-		tlsConn := tls.Server(conn, &tls.Config{
-			Certificates: []tls.Certificate{h.Cert},
-			NextProtos: []string{
-				"h2",
-				"http/1.1",
-			},
-		})
-
-		if e := tlsConn.Handshake(); e != nil {
-			log.Println("handshake error:", e)
+		username, password, _ := common.ProxyCredentials(request.Header)
+		if !h.Options.Users.Authenticate(username, password) {
+			if !h.requireAuth(conn, request, username) {
+				return
+			}
+			continue
+		}
+		client := common.NewClientInfo(conn.RemoteAddr(), username, h.Options)
+		if request.Method == http.MethodConnect {
+			h.processConnect(conn, connReader, request, client)
 			return
 		}
-		if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
-			common.ProcessHttp2ProxyRequest(tlsConn, request.URL, "")
-		} else {
-			common.ProcessHttp11ProxyRequest(tlsConn, request, true, "")
+		if !common.ServeHttp11Request(conn, connReader, request, "", client) {
+			return
 		}
+	}
+}
+
+// requireAuth 返回407要求客户端提供代理用户名和密码, 返回连接能否继续使用
+func (h httpProxyHandler) requireAuth(conn net.Conn, request *http.Request, username string) bool {
+	if username != "" {
+		log.Println("proxy auth failed:", username, conn.RemoteAddr())
+	}
+	body := "Proxy Authentication Required"
+	// 没有读取请求体, 有请求体时不能继续读取下一个请求
+	keepAlive := !request.Close && (request.Body == nil || request.Body == http.NoBody)
+	response := &http.Response{
+		StatusCode:    http.StatusProxyAuthRequired,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       request,
+		Header:        http.Header{"Proxy-Authenticate": {`Basic realm="asyncProxy"`}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Close:         !keepAlive,
+	}
+	if e := response.Write(conn); e != nil {
+		log.Println("write error:", e)
+		return false
+	}
+	return keepAlive
+}
+
+func (h httpProxyHandler) processConnect(conn net.Conn, connReader *bufio.Reader, request *http.Request, client *common.ClientInfo) {
+	if reason, ok := h.Options.Tunnel.Match(request.Host); ok {
+		h.processTunnel(conn, connReader, request.Host, reason, client)
+		return
+	}
+	log.Println("mitm:", request.Host, client)
+	_, e := fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	if e != nil {
+		log.Println("write error:", e)
+		return
+	}
+
+	tlsConn, e := common.HandshakeTLS(conn, request.Host, h.Options)
+	if e != nil {
+		log.Println("handshake error:", e)
+		return
+	}
+	if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+		common.ProcessHttp2ProxyRequest(tlsConn, request.URL, "", client)
 	} else {
-		common.ProcessHttp11ProxyRequest(conn, request, false, "")
+		common.ProcessHttp11ProxyRequest(tlsConn, bufio.NewReader(tlsConn), nil, request.URL.Host, "", client)
+	}
+}
+
+// processTunnel 不解密TLS, 通过边缘节点隧道原样转发CONNECT之后的字节
+func (h httpProxyHandler) processTunnel(conn net.Conn, connReader *bufio.Reader, address, reason string,
+	client *common.ClientInfo) {
+	stream, e := common.OpenTunnel(address, client)
+	if e != nil {
+		log.Println("open tunnel error:", e)
+		_, _ = fmt.Fprint(conn, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+		return
+	}
+	_, e = fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	if e != nil {
+		log.Println("write error:", e)
+		_ = stream.Close()
+		return
 	}
+	common.RelayTunnel(connReader, conn, stream, address, reason, client)
 }
@@ -1,249 +1,196 @@
 package socks5Proxy
 
 import (
-	"asyncProxy/errors"
 	"asyncProxy/proxy/common"
 	"bufio"
-	"bytes"
 	"context"
-	"crypto/tls"
 	"fmt"
 	"github.com/things-go/go-socks5"
 	"github.com/things-go/go-socks5/statute"
 	"io"
 	"log"
 	"net"
-	"net/http"
 	"net/url"
 	"strconv"
 	"time"
 )
 
 type Socks5Proxy struct {
-	host string
-	port uint16
+	host    string
+	port    uint16
+	options *common.ProxyOptions
 }
 
-func NewProxy(host string, port uint16) *Socks5Proxy {
+func NewProxy(host string, port uint16, options *common.ProxyOptions) *Socks5Proxy {
+	if options.UdpIdleTimeout <= 0 {
+		options.UdpIdleTimeout = time.Minute
+	}
 	return &Socks5Proxy{
-		host: host,
-		port: port,
+		host:    host,
+		port:    port,
+		options: options,
 	}
 }
 
 func (receiver Socks5Proxy) ListenAndServe() {
-
-	fakeCert, fakeKey, e := common.GenerateFakeCert()
-	if e != nil {
-		log.Fatalln("error while generate fake cert:", e)
-	}
-
-	tlsCert, e := tls.X509KeyPair(fakeCert, fakeKey)
-	if e != nil {
-		log.Fatalln("error while loading fake cert:", e)
-	}
 	h := &socks5Handler{
-		cert: tlsCert,
+		options: receiver.options,
 	}
-	serv := socks5.NewServer(socks5.WithConnectHandle(h.ConnectHandle))
+	serv := socks5.NewServer(
+		// 客户端提供了用户名时使用用户名认证, 用户名中可以携带会话等参数
+		socks5.WithAuthMethods([]socks5.Authenticator{
+			socks5.UserPassAuthenticator{Credentials: credentials{users: receiver.options.Users}},
+			noAuth{users: receiver.options.Users},
+		}),
+		socks5.WithConnectHandle(h.ConnectHandle),
+		socks5.WithAssociateHandle(h.AssociateHandle),
+		socks5.WithResolver(caResolver{}),
+	)
 
 	log.Println("start to listen socks5")
-	e = serv.ListenAndServe("tcp", fmt.Sprintf("%s:%d", receiver.host, receiver.port))
+	e := serv.ListenAndServe("tcp", fmt.Sprintf("%s:%d", receiver.host, receiver.port))
 	if e != nil {
 		log.Fatalln("error while listen to socks5:", e)
 	}
 }
 
 type socks5Handler struct {
-	cert tls.Certificate
+	options *common.ProxyOptions
 }
 
-type socks5NetConn struct {
-	Socks5Request *socks5.Request
-	Writer        io.Writer
-	deadLine      *time.Time
-	writeDeadLine *time.Time
-	readDeadLine  *time.Time
+// caResolver 证书安装页面的主机名无法通过DNS解析, 由代理直接处理
+type caResolver struct {
+	socks5.DNSResolver
 }
 
-func (s socks5NetConn) Read(b []byte) (n int, err error) {
-	var timer time.Duration
-	if s.readDeadLine != nil {
-		timer = time.Since(*s.readDeadLine)
-	} else if s.deadLine != nil {
-		timer = time.Since(*s.deadLine)
-	}
-	if timer < 0 {
-		return 0, io.ErrNoProgress
-	} else if timer != 0 {
-		return readTimeout(s.Socks5Request.Reader, b, timer)
+func (r caResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	if common.IsCAHost(name) {
+		return ctx, net.IPv4zero, nil
 	}
-
-	return s.Socks5Request.Reader.Read(b)
+	return r.DNSResolver.Resolve(ctx, name)
 }
 
-func (s socks5NetConn) Write(b []byte) (n int, err error) {
-	var timer time.Duration
-	if s.writeDeadLine != nil {
-		timer = time.Since(*s.writeDeadLine)
-	} else if s.deadLine != nil {
-		timer = time.Since(*s.deadLine)
-	}
-	if timer < 0 {
-		return 0, io.ErrNoProgress
-	} else if timer != 0 {
-		return writeTimeout(s.Writer, b, timer)
-	}
-	return s.Writer.Write(b)
+// credentials 按不含参数的用户名校验RFC 1929用户名密码认证
+type credentials struct {
+	users *common.UserStore
 }
 
-func (s socks5NetConn) Close() error {
-	return socks5.SendReply(s.Writer, statute.RepServerFailure, s.Socks5Request.LocalAddr)
+func (c credentials) Valid(user, password, userAddr string) bool {
+	if c.users.Authenticate(user, password) {
+		return true
+	}
+	log.Println("proxy auth failed:", user, userAddr)
+	return false
 }
 
-func (s socks5NetConn) LocalAddr() net.Addr {
-	return s.Socks5Request.LocalAddr
+// noAuth 没有配置代理用户时允许客户端不认证
+type noAuth struct {
+	users *common.UserStore
 }
 
-func (s socks5NetConn) RemoteAddr() net.Addr {
-	return s.Socks5Request.RemoteAddr
+func (noAuth) GetCode() uint8 {
+	return statute.MethodNoAuth
 }
 
-func (s socks5NetConn) SetDeadline(t time.Time) error {
-	s.deadLine = &t
-	return nil
+func (a noAuth) Authenticate(reader io.Reader, writer io.Writer, userAddr string) (*socks5.AuthContext, error) {
+	if a.users.Enabled() {
+		_, _ = writer.Write([]byte{statute.VersionSocks5, statute.MethodNoAcceptable})
+		return nil, statute.ErrNoSupportedAuth
+	}
+	return socks5.NoAuthAuthenticator{}.Authenticate(reader, writer, userAddr)
 }
 
-func (s socks5NetConn) SetReadDeadline(t time.Time) error {
-	s.readDeadLine = &t
-	return nil
+// clientInfo 根据认证结果生成客户端信息
+func (h socks5Handler) clientInfo(request *socks5.Request) *common.ClientInfo {
+	username := ""
+	if request.AuthContext != nil {
+		username = request.AuthContext.Payload["username"]
+	}
+	return common.NewClientInfo(request.RemoteAddr, username, h.options)
 }
 
-func (s socks5NetConn) SetWriteDeadline(t time.Time) error {
-	s.writeDeadLine = &t
-	return nil
+// socks5NetConn 从socks5请求的reader读取客户端数据(其中可能有已缓冲的数据), 写入和超时使用客户端连接
+type socks5NetConn struct {
+	net.Conn
+	reader io.Reader
 }
 
-type readWriteResult struct {
-	N   int
-	Err error
+func (s socks5NetConn) Read(b []byte) (int, error) {
+	return s.reader.Read(b)
 }
 
-func readTimeout(reader io.Reader, b []byte, timeout time.Duration) (n int, err error) {
-	timeoutTimer := time.After(timeout)
-	readResult := make(chan readWriteResult)
-	go func() {
-		result, err := reader.Read(b)
-		readResult <- readWriteResult{
-			N:   result,
-			Err: err,
-		}
-	}()
+func (h socks5Handler) ConnectHandle(_ context.Context, writer io.Writer, request *socks5.Request) error {
+	address := destinationAddress(request)
+	client := h.clientInfo(request)
+	if reason, ok := h.options.Tunnel.Match(address, request.DestAddr.String()); ok {
+		return h.processTunnel(writer, request, address, reason, client)
+	}
 
-	select {
-	case rs := <-readResult:
-		return rs.N, rs.Err
-	case <-timeoutTimer:
-		return 0, io.ErrNoProgress
+	conn, ok := writer.(net.Conn)
+	if !ok {
+		return fmt.Errorf("unexpected socks5 writer: %T", writer)
 	}
-}
+	e := socks5.SendReply(writer, statute.RepSuccess, request.LocalAddr)
+	if e != nil {
+		log.Println("send success failed:", e)
+	}
+	// 预读首字节判断是否为TLS
+	reader := bufio.NewReader(request.Reader)
+	firstByte, e := common.PeekFirstByte(conn, reader, h.options)
+	if e != nil {
+		log.Println("read first byte error:", e)
+		return e
+	}
+	netConn := socks5NetConn{Conn: conn, reader: reader}
+	port := strconv.Itoa(request.DstAddr.Port)
 
-func writeTimeout(reader io.Writer, b []byte, timeout time.Duration) (n int, err error) {
-	timeoutTimer := time.After(timeout)
-	readResult := make(chan readWriteResult)
-	go func() {
-		result, err := reader.Write(b)
-		readResult <- readWriteResult{
-			N:   result,
-			Err: err,
+	if !isHttps(firstByte) {
+		common.ProcessHttp11ProxyRequest(netConn, reader, nil, "", port, client)
+		return nil
+	}
+	log.Println("mitm:", address, client)
+	tlsConn, e := common.HandshakeTLS(netConn, address, h.options)
+	if e != nil {
+		log.Println("handshake error:", e)
+		return e
+	}
+	if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+		originUrl := &url.URL{}
+		originUrl.Scheme = "https"
+		originUrl.Host = tlsConn.ConnectionState().ServerName
+		if originUrl.Host == "" {
+			originUrl.Host = fmt.Sprintf("%v:%v", request.DstAddr.IP, request.DstAddr.Port)
 		}
-	}()
-
-	select {
-	case rs := <-readResult:
-		return rs.N, rs.Err
-	case <-timeoutTimer:
-		return 0, io.ErrNoProgress
+		common.ProcessHttp2ProxyRequest(tlsConn, originUrl, port, client)
+	} else {
+		common.ProcessHttp11ProxyRequest(tlsConn, bufio.NewReader(tlsConn), nil, "", port, client)
 	}
+	return nil
 }
 
-func (h socks5Handler) ConnectHandle(_ context.Context, writer io.Writer, request *socks5.Request) error {
-	processFunc := func(writer io.Writer, request *socks5.Request) error {
-		netConn := socks5NetConn{
-			Socks5Request: request,
-			Writer:        writer,
-			deadLine:      nil,
-			writeDeadLine: nil,
-			readDeadLine:  nil,
-		}
-
-		e := socks5.SendReply(writer, statute.RepSuccess, request.LocalAddr)
-		if e != nil {
-			log.Println("send success failed:", e)
-		}
-		// 读出首字节
-		firstByte := make([]byte, 1)
-
-		_, e = io.ReadFull(request.Reader, firstByte)
-		if e != nil {
-			log.Println("read first byte error:", e)
-			return e
-		}
-
-		request.Reader = io.MultiReader(bytes.NewReader(firstByte), request.Reader)
-
-		if isHttps(firstByte[0]) {
-			tlsConn := tls.Server(netConn, &tls.Config{
-				Certificates: []tls.Certificate{h.cert},
-				NextProtos: []string{
-					"h2",
-					"http/1.1",
-				}})
-			if e := tlsConn.Handshake(); e != nil {
-				log.Println("handshake error:", e)
-			}
-
-			if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
-				originUrl := &url.URL{}
-				originUrl.Scheme = "https"
-				originUrl.Host = tlsConn.ConnectionState().ServerName
-				if originUrl.Host == "" {
-					originUrl.Host = fmt.Sprintf("%v:%v", request.DstAddr.IP, request.DstAddr.Port)
-				}
-				common.ProcessHttp2ProxyRequest(tlsConn, originUrl, strconv.Itoa(request.DstAddr.Port))
-			} else {
-				req, e := http.ReadRequest(bufio.NewReader(tlsConn))
-				if e != nil {
-					log.Println("malformed http request:", e)
-					return e
-				}
-				common.ProcessHttp11ProxyRequest(tlsConn, req, true, strconv.Itoa(request.DstAddr.Port))
-			}
-
-		} else {
-			req, e := http.ReadRequest(bufio.NewReader(netConn))
-			if e != nil {
-				log.Println("malformed http request:", e)
-				return e
-			}
-			common.ProcessHttp11ProxyRequest(netConn, req, false, strconv.Itoa(request.DstAddr.Port))
-		}
-		return nil
+// processTunnel 不解密TLS, 通过边缘节点隧道原样转发字节
+func (h socks5Handler) processTunnel(writer io.Writer, request *socks5.Request, address, reason string, client *common.ClientInfo) error {
+	stream, e := common.OpenTunnel(address, client)
+	if e != nil {
+		log.Println("open tunnel error:", e)
+		_ = socks5.SendReply(writer, statute.RepHostUnreachable, nil)
+		return e
 	}
-
-	timer := time.After(1 * time.Minute)
-	completeChan := make(chan error)
-	go func() {
-		e := processFunc(writer, request)
-		completeChan <- e
-		close(completeChan)
-	}()
-	select {
-	case e := <-completeChan:
+	e = socks5.SendReply(writer, statute.RepSuccess, request.LocalAddr)
+	if e != nil {
+		_ = stream.Close()
 		return e
-	case <-timer:
-		return errors.NewBusinessError(500, "请求处理超时")
 	}
+	common.RelayTunnel(request.Reader, writer, stream, address, reason, client)
+	return nil
+}
+
+// destinationAddress 优先使用客户端给出的域名, 由边缘节点自行解析
+func destinationAddress(request *socks5.Request) string {
+	if request.RawDestAddr.FQDN != "" {
+		return net.JoinHostPort(request.RawDestAddr.FQDN, strconv.Itoa(request.RawDestAddr.Port))
+	}
+	return request.RawDestAddr.String()
 }
 
 func isHttps(b byte) bool {
@@ -0,0 +1,113 @@
+package socks5Proxy
+
+import (
+	"asyncProxy/ws"
+	"asyncProxy/ws/transport"
+	"context"
+	"github.com/things-go/go-socks5"
+	"github.com/things-go/go-socks5/statute"
+	"io"
+	"log"
+	"net"
+	"sync/atomic"
+)
+
+// AssociateHandle 处理UDP ASSOCIATE, 客户端的数据报通过边缘节点的UDP端口收发
+func (h socks5Handler) AssociateHandle(_ context.Context, writer io.Writer, request *socks5.Request) error {
+	var bindIp net.IP
+	if tcpAddr, ok := request.LocalAddr.(*net.TCPAddr); ok {
+		bindIp = tcpAddr.IP
+	}
+	bindLn, e := net.ListenUDP("udp", &net.UDPAddr{IP: bindIp})
+	if e != nil {
+		_ = socks5.SendReply(writer, statute.RepServerFailure, nil)
+		return e
+	}
+	defer bindLn.Close()
+
+	association, e := ws.OpenAssociation(h.options.UdpIdleTimeout, h.clientInfo(request).DispatchOptions(nil))
+	if e != nil {
+		log.Println("open udp association error:", e)
+		_ = socks5.SendReply(writer, statute.RepServerFailure, nil)
+		return e
+	}
+	defer association.Close()
+
+	if e := socks5.SendReply(writer, statute.RepSuccess, bindLn.LocalAddr()); e != nil {
+		return e
+	}
+
+	var clientAddr atomic.Pointer[net.UDPAddr]
+	go relayClientDatagrams(bindLn, request, association, &clientAddr)
+	go relayEdgeDatagrams(bindLn, association, &clientAddr)
+
+	// 控制连接断开或关联超时关闭时结束
+	go func() {
+		<-association.Done()
+		_ = bindLn.Close()
+		if conn, ok := writer.(net.Conn); ok {
+			_ = conn.Close()
+		}
+	}()
+	_, _ = io.Copy(io.Discard, request.Reader)
+	return nil
+}
+
+// relayClientDatagrams 读取客户端的数据报并转发给边缘节点
+func relayClientDatagrams(bindLn *net.UDPConn, request *socks5.Request, association *transport.Association,
+	clientAddr *atomic.Pointer[net.UDPAddr]) {
+	var clientIp net.IP
+	if tcpAddr, ok := request.RemoteAddr.(*net.TCPAddr); ok {
+		clientIp = tcpAddr.IP
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		n, srcAddr, e := bindLn.ReadFromUDP(buf)
+		if e != nil {
+			_ = association.Close()
+			return
+		}
+		// 只接受建立关联的客户端发来的数据报
+		if clientIp != nil && !clientIp.Equal(srcAddr.IP) {
+			continue
+		}
+		if request.DestAddr.Port != 0 && request.DestAddr.Port != srcAddr.Port {
+			continue
+		}
+		datagram, e := statute.ParseDatagram(buf[:n])
+		if e != nil || datagram.Frag != 0 {
+			// 不支持分片
+			continue
+		}
+		clientAddr.Store(srcAddr)
+		e = association.Send(&transport.Datagram{
+			Address: datagram.DstAddr.String(),
+			Payload: datagram.Data,
+		})
+		if e != nil {
+			return
+		}
+	}
+}
+
+// relayEdgeDatagrams 把边缘节点收到的数据报加上SOCKS5头发回客户端
+func relayEdgeDatagrams(bindLn *net.UDPConn, association *transport.Association, clientAddr *atomic.Pointer[net.UDPAddr]) {
+	for {
+		select {
+		case <-association.Done():
+			return
+		case datagram := <-association.Datagrams():
+			addr := clientAddr.Load()
+			if addr == nil {
+				continue
+			}
+			packet, e := statute.NewDatagram(datagram.Address, datagram.Payload)
+			if e != nil {
+				continue
+			}
+			if _, e := bindLn.WriteToUDP(packet.Bytes(), addr); e != nil {
+				log.Println("udp write to client error:", e)
+			}
+		}
+	}
+}
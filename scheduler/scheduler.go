@@ -0,0 +1,284 @@
+package scheduler
+
+import (
+	"asyncProxy/job"
+	"context"
+	goerrors "errors"
+	"fmt"
+	"github.com/oklog/ulid/v2"
+	"github.com/robfig/cron/v3"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// historySize 每个定时任务保留的执行记录数
+const historySize = 20
+
+// ErrNotFound 定时任务不存在
+var ErrNotFound = goerrors.New("schedule not found")
+
+// Definition 定时任务的定义, Cron和Interval二选一
+type Definition struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// Cron 5段cron表达式, 也支持@hourly、@every 5m等写法
+	Cron string `json:"cron"`
+	// Interval 固定间隔(秒)
+	Interval int `json:"interval"`
+	// Paused 暂停的任务不再调度, 仍可手动执行
+	Paused bool `json:"paused"`
+	// Request 每次执行的请求, 不支持结果回调
+	Request job.Request `json:"request"`
+}
+
+// Validate 检查定义并补全请求的默认值
+func (d *Definition) Validate() error {
+	if _, e := d.schedule(); e != nil {
+		return e
+	}
+	if d.Request.Callback != "" {
+		return goerrors.New("callback is not supported for schedules")
+	}
+	return d.Request.Validate()
+}
+
+func (d *Definition) schedule() (cron.Schedule, error) {
+	switch {
+	case d.Cron != "" && d.Interval != 0:
+		return nil, goerrors.New("only one of cron and interval can be set")
+	case d.Cron != "":
+		schedule, e := cron.ParseStandard(d.Cron)
+		if e != nil {
+			return nil, fmt.Errorf("invalid cron: %w", e)
+		}
+		return schedule, nil
+	case d.Interval > 0:
+		return cron.Every(time.Duration(d.Interval) * time.Second), nil
+	default:
+		return nil, goerrors.New("cron or interval is required")
+	}
+}
+
+// Run 一次执行的记录
+type Run struct {
+	StartedAt  time.Time  `json:"startedAt"`
+	Status     job.Status `json:"status"`
+	StatusCode int        `json:"statusCode,omitempty"`
+	EdgeId     string     `json:"edgeId,omitempty"`
+	ElapsedMs  int64      `json:"elapsedMs"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// View 定时任务的定义和执行情况, History按时间倒序
+type View struct {
+	Definition
+	Next    *time.Time `json:"next,omitempty"`
+	Running bool       `json:"running"`
+	History []Run      `json:"history"`
+	// Last 最近一次执行的完整结果, 列表中不返回
+	Last *job.BatchItem `json:"last,omitempty"`
+}
+
+type schedule struct {
+	definition Definition
+	entry      cron.EntryID
+	running    atomic.Bool
+	history    []Run
+	last       *job.BatchItem
+}
+
+// Scheduler 按cron表达式或固定间隔通过边缘节点执行请求
+type Scheduler struct {
+	jobs *job.Manager
+	cron *cron.Cron
+
+	mu        sync.RWMutex
+	schedules map[string]*schedule
+}
+
+func NewScheduler(jobs *job.Manager) *Scheduler {
+	return &Scheduler{
+		jobs:      jobs,
+		cron:      cron.New(),
+		schedules: map[string]*schedule{},
+	}
+}
+
+// Start 开始调度
+func (s *Scheduler) Start() {
+	s.cron.Start()
+	log.Println("scheduler is started")
+}
+
+// Add 添加定时任务, 返回分配了ID的定义
+func (s *Scheduler) Add(definition Definition) (View, error) {
+	if e := definition.Validate(); e != nil {
+		return View{}, e
+	}
+	definition.Id = ulid.Make().String()
+	sc := &schedule{definition: definition}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.registerLocked(sc); e != nil {
+		return View{}, e
+	}
+	s.schedules[definition.Id] = sc
+	return s.viewLocked(sc, false), nil
+}
+
+// Update 修改定时任务, 执行记录保留
+func (s *Scheduler) Update(id string, definition Definition) (View, error) {
+	if e := definition.Validate(); e != nil {
+		return View{}, e
+	}
+	definition.Id = id
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc, ok := s.schedules[id]
+	if !ok {
+		return View{}, ErrNotFound
+	}
+	s.cron.Remove(sc.entry)
+	sc.definition = definition
+	if e := s.registerLocked(sc); e != nil {
+		return View{}, e
+	}
+	return s.viewLocked(sc, false), nil
+}
+
+// SetPaused 暂停或恢复定时任务
+func (s *Scheduler) SetPaused(id string, paused bool) (View, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc, ok := s.schedules[id]
+	if !ok {
+		return View{}, ErrNotFound
+	}
+	s.cron.Remove(sc.entry)
+	sc.definition.Paused = paused
+	if e := s.registerLocked(sc); e != nil {
+		return View{}, e
+	}
+	return s.viewLocked(sc, false), nil
+}
+
+func (s *Scheduler) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc, ok := s.schedules[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.cron.Remove(sc.entry)
+	delete(s.schedules, id)
+	return nil
+}
+
+// Trigger 立即执行一次, 不影响原有的调度
+func (s *Scheduler) Trigger(id string) error {
+	s.mu.RLock()
+	sc, ok := s.schedules[id]
+	s.mu.RUnlock()
+	if !ok {
+		return ErrNotFound
+	}
+	go s.run(sc)
+	return nil
+}
+
+func (s *Scheduler) Get(id string) (View, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sc, ok := s.schedules[id]
+	if !ok {
+		return View{}, false
+	}
+	return s.viewLocked(sc, true), true
+}
+
+// List 按名称排序的所有定时任务
+func (s *Scheduler) List() []View {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	views := make([]View, 0, len(s.schedules))
+	for _, sc := range s.schedules {
+		views = append(views, s.viewLocked(sc, false))
+	}
+	sort.Slice(views, func(i, j int) bool {
+		if views[i].Name != views[j].Name {
+			return views[i].Name < views[j].Name
+		}
+		return views[i].Id < views[j].Id
+	})
+	return views
+}
+
+// registerLocked 没有暂停的任务加入调度
+func (s *Scheduler) registerLocked(sc *schedule) error {
+	sc.entry = 0
+	if sc.definition.Paused {
+		return nil
+	}
+	next, e := sc.definition.schedule()
+	if e != nil {
+		return e
+	}
+	sc.entry = s.cron.Schedule(next, cron.FuncJob(func() {
+		s.run(sc)
+	}))
+	return nil
+}
+
+func (s *Scheduler) viewLocked(sc *schedule, detail bool) View {
+	view := View{
+		Definition: sc.definition,
+		Running:    sc.running.Load(),
+		History:    make([]Run, 0, len(sc.history)),
+	}
+	for i := len(sc.history) - 1; i >= 0; i-- {
+		view.History = append(view.History, sc.history[i])
+	}
+	if sc.entry != 0 {
+		if next := s.cron.Entry(sc.entry).Next; !next.IsZero() {
+			view.Next = &next
+		}
+	}
+	if detail {
+		view.Last = sc.last
+	}
+	return view
+}
+
+// run 执行一次, 上一次还没结束时跳过
+func (s *Scheduler) run(sc *schedule) {
+	if !sc.running.CompareAndSwap(false, true) {
+		log.Println("定时任务上一次执行尚未结束, 跳过:", sc.definition.Name)
+		return
+	}
+	defer sc.running.Store(false)
+
+	s.mu.RLock()
+	request := sc.definition.Request
+	s.mu.RUnlock()
+	startedAt := time.Now()
+	item := s.jobs.Execute(context.Background(), &request)
+	run := Run{StartedAt: startedAt, Status: item.Status, ElapsedMs: item.ElapsedMs}
+	if item.Response != nil {
+		run.StatusCode = item.Response.StatusCode
+		run.EdgeId = item.Response.EdgeId
+	}
+	if item.Error != nil {
+		run.Error = item.Error.Message
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc.last = &item
+	sc.history = append(sc.history, run)
+	if len(sc.history) > historySize {
+		sc.history = sc.history[len(sc.history)-historySize:]
+	}
+}
@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"asyncProxy/job"
+	"testing"
+	"time"
+)
+
+func TestDefinitionValidate(t *testing.T) {
+	request := job.Request{Url: "https://example.com/health"}
+	tests := []struct {
+		name       string
+		definition Definition
+		wantErr    bool
+	}{
+		{"cron", Definition{Cron: "*/5 * * * *", Request: request}, false},
+		{"cron descriptor", Definition{Cron: "@hourly", Request: request}, false},
+		{"cron every", Definition{Cron: "@every 5m", Request: request}, false},
+		{"interval", Definition{Interval: 30, Request: request}, false},
+		{"neither", Definition{Request: request}, true},
+		{"both", Definition{Cron: "@hourly", Interval: 30, Request: request}, true},
+		{"negative interval", Definition{Interval: -1, Request: request}, true},
+		{"invalid cron", Definition{Cron: "every five minutes", Request: request}, true},
+		{"six field cron", Definition{Cron: "0 */5 * * * *", Request: request}, true},
+		{"callback", Definition{Interval: 30, Request: job.Request{Url: request.Url, Callback: "https://example.com/cb"}}, true},
+		{"invalid url", Definition{Interval: 30, Request: job.Request{Url: "ftp://example.com"}}, true},
+		{"invalid priority", Definition{Interval: 30, Request: job.Request{Url: request.Url, Priority: "urgent"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if e := tt.definition.Validate(); (e != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", e, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefinitionValidateDefaults(t *testing.T) {
+	definition := Definition{Interval: 30, Request: job.Request{Method: "post", Url: "https://example.com"}}
+	if e := definition.Validate(); e != nil {
+		t.Fatal(e)
+	}
+	if definition.Request.Method != "POST" {
+		t.Fatalf("method = %q", definition.Request.Method)
+	}
+
+	definition = Definition{Interval: 30, Request: job.Request{Url: "https://example.com"}}
+	if e := definition.Validate(); e != nil {
+		t.Fatal(e)
+	}
+	if definition.Request.Method != "GET" {
+		t.Fatalf("default method = %q", definition.Request.Method)
+	}
+}
+
+func TestDefinitionSchedule(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 7, 0, 0, time.Local)
+	tests := []struct {
+		name       string
+		definition Definition
+		want       time.Time
+	}{
+		{"interval", Definition{Interval: 90}, start.Add(90 * time.Second)},
+		{"cron", Definition{Cron: "*/5 * * * *"}, time.Date(2024, 1, 1, 10, 10, 0, 0, time.Local)},
+		{"hourly", Definition{Cron: "@hourly"}, time.Date(2024, 1, 1, 11, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, e := tt.definition.schedule()
+			if e != nil {
+				t.Fatal(e)
+			}
+			if got := schedule.Next(start); !got.Equal(tt.want) {
+				t.Fatalf("next = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
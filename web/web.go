@@ -1,8 +1,18 @@
 package web
 
 import (
+	"asyncProxy/errors"
+	"asyncProxy/job"
+	"asyncProxy/proxy/common"
+	"asyncProxy/scheduler"
 	"asyncProxy/web/views"
 	"asyncProxy/ws"
+	"asyncProxy/ws/edge"
+	"asyncProxy/ws/transport"
+	"bufio"
+	"context"
+	"encoding/json"
+	goerrors "errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/basicauth"
@@ -10,9 +20,78 @@ import (
 	"github.com/gofiber/template/html/v2"
 	"log"
 	"net/http"
+	"time"
 )
 
-func Start(host string, port uint16, username, password string) {
+// edgeView 页面上展示的节点信息
+type edgeView struct {
+	Address     string
+	Labels      string
+	State       string
+	Requests    int
+	SuccessRate string
+	TimeoutRate string
+	AvgLatency  string
+	Rtt         string
+	InFlight    string
+}
+
+// inFlight 处理中的数量, 有并发上限时显示为 处理中/上限
+func inFlight(e *edge.Edge) string {
+	if e.Info.MaxInFlight <= 0 {
+		return fmt.Sprint(e.InFlight.Load())
+	}
+	return fmt.Sprintf("%d/%d", e.InFlight.Load(), e.Info.MaxInFlight)
+}
+
+// errorMessage 业务错误只返回错误信息
+func errorMessage(e error) string {
+	var businessError *errors.BusinessError
+	if goerrors.As(e, &businessError) {
+		return businessError.Message
+	}
+	return e.Error()
+}
+
+// scheduleForm 页面上添加定时任务的表单
+type scheduleForm struct {
+	Name     string `form:"name"`
+	Cron     string `form:"cron"`
+	Interval int    `form:"interval"`
+	Method   string `form:"method"`
+	Url      string `form:"url"`
+}
+
+var errUnknownAction = goerrors.New("unknown action")
+
+// scheduleAction 暂停、恢复、立即执行或删除定时任务
+func scheduleAction(schedules *scheduler.Scheduler, id, action string) error {
+	var e error
+	switch action {
+	case "pause":
+		_, e = schedules.SetPaused(id, true)
+	case "resume":
+		_, e = schedules.SetPaused(id, false)
+	case "run":
+		e = schedules.Trigger(id)
+	case "delete":
+		e = schedules.Remove(id)
+	default:
+		e = errUnknownAction
+	}
+	return e
+}
+
+// scheduleStatus 定时任务操作的错误对应的状态码
+func scheduleStatus(e error) int {
+	if goerrors.Is(e, scheduler.ErrNotFound) || goerrors.Is(e, errUnknownAction) {
+		return fiber.StatusNotFound
+	}
+	return fiber.StatusBadRequest
+}
+
+func Start(host string, port uint16, username, password string, jobs *job.Manager, schedules *scheduler.Scheduler,
+	ca *common.CertificateAuthority) {
 	engine := html.NewFileSystem(http.FS(views.Views), ".html")
 	engine.Reload(false)
 	engine.Debug(false)
@@ -36,17 +115,164 @@ func Start(host string, port uint16, username, password string) {
 	})
 	app.Get("/", auth, func(c *fiber.Ctx) error {
 		data := set.Data()
-		list := make([]string, 0)
+		list := make([]edgeView, 0)
 		for _, edge := range data {
 			addr := edge.Conn.RemoteAddr()
-			if addr != nil {
-				list = append(list, addr.String())
+			if addr == nil {
+				continue
 			}
+			stats := edge.Health()
+			list = append(list, edgeView{
+				Address:     addr.String(),
+				Labels:      transport.FormatLabels(edge.Info.Labels),
+				State:       stats.State.String(),
+				Requests:    stats.Requests,
+				SuccessRate: fmt.Sprintf("%.1f%%", stats.SuccessRate*100),
+				TimeoutRate: fmt.Sprintf("%.1f%%", stats.TimeoutRate*100),
+				AvgLatency:  stats.AvgLatency.Round(time.Millisecond).String(),
+				Rtt:         edge.Rtt().Round(time.Millisecond).String(),
+				InFlight:    inFlight(edge),
+			})
 		}
 		return c.Render("index", fiber.Map{
-			"Total": set.Len(),
-			"List":  list,
+			"Total":     set.Len(),
+			"Queue":     set.QueueLen(),
+			"List":      list,
+			"Schedules": schedules.List(),
+		})
+	})
+	app.Post("/schedules", auth, func(c *fiber.Ctx) error {
+		var form scheduleForm
+		if e := c.BodyParser(&form); e != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(e.Error())
+		}
+		_, e := schedules.Add(scheduler.Definition{
+			Name:     form.Name,
+			Cron:     form.Cron,
+			Interval: form.Interval,
+			Request:  job.Request{Method: form.Method, Url: form.Url},
+		})
+		if e != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(e.Error())
+		}
+		return c.Redirect("/")
+	})
+	app.Post("/schedules/:id/:action", auth, func(c *fiber.Ctx) error {
+		if e := scheduleAction(schedules, c.Params("id"), c.Params("action")); e != nil {
+			return c.Status(scheduleStatus(e)).SendString(e.Error())
+		}
+		return c.Redirect("/")
+	})
+	// 根证书是公开的, 下载和安装页面不需要登录, 方便在手机上直接打开
+	app.Get("/ca", func(c *fiber.Ctx) error {
+		c.Type("html", "utf-8")
+		return c.Send(ca.InstallPage("/ca"))
+	})
+	app.Get("/ca/:file", func(c *fiber.Ctx) error {
+		file, ok := ca.ExportFile(c.Params("file"))
+		if !ok {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+		c.Attachment(file.Name)
+		c.Set(fiber.HeaderContentType, file.ContentType)
+		return c.Send(file.Content)
+	})
+	api := app.Group("/api", auth)
+	api.Post("/jobs", func(c *fiber.Ctx) error {
+		var request job.Request
+		if e := c.BodyParser(&request); e != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": e.Error()})
+		}
+		if e := request.Validate(); e != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": e.Error()})
+		}
+		submitted, e := jobs.Submit(&request)
+		if e != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": errorMessage(e)})
+		}
+		return c.Status(fiber.StatusAccepted).JSON(submitted)
+	})
+	api.Get("/jobs/:id", func(c *fiber.Ctx) error {
+		found, ok := jobs.Get(c.Params("id"))
+		if !ok {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "job not found"})
+		}
+		return c.JSON(found)
+	})
+	api.Post("/batch", func(c *fiber.Ctx) error {
+		var batch job.BatchRequest
+		if e := c.BodyParser(&batch); e != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": e.Error()})
+		}
+		if e := jobs.ValidateBatch(&batch); e != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": e.Error()})
+		}
+		if !batch.Stream {
+			// fasthttp在客户端断开时不会结束请求的ctx, 超过最长执行时间或处理结束时取消剩余请求
+			ctx, cancel := context.WithTimeout(context.Background(), jobs.BatchTimeout())
+			defer cancel()
+			return c.JSON(jobs.RunBatchAndWait(ctx, &batch))
+		}
+		// 每完成一个请求输出一行, 客户端断开时取消剩余请求
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			jobs.RunBatch(ctx, &batch, func(item job.BatchItem) {
+				line, _ := json.Marshal(&item)
+				_, _ = w.Write(append(line, '\n'))
+				if e := w.Flush(); e != nil {
+					cancel()
+				}
+			})
 		})
+		return nil
+	})
+	api.Get("/schedules", func(c *fiber.Ctx) error {
+		return c.JSON(schedules.List())
+	})
+	api.Post("/schedules", func(c *fiber.Ctx) error {
+		var definition scheduler.Definition
+		if e := c.BodyParser(&definition); e != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": e.Error()})
+		}
+		view, e := schedules.Add(definition)
+		if e != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": e.Error()})
+		}
+		return c.Status(fiber.StatusCreated).JSON(view)
+	})
+	api.Get("/schedules/:id", func(c *fiber.Ctx) error {
+		view, ok := schedules.Get(c.Params("id"))
+		if !ok {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": scheduler.ErrNotFound.Error()})
+		}
+		return c.JSON(view)
+	})
+	api.Put("/schedules/:id", func(c *fiber.Ctx) error {
+		var definition scheduler.Definition
+		if e := c.BodyParser(&definition); e != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": e.Error()})
+		}
+		view, e := schedules.Update(c.Params("id"), definition)
+		if e != nil {
+			return c.Status(scheduleStatus(e)).JSON(fiber.Map{"error": e.Error()})
+		}
+		return c.JSON(view)
+	})
+	api.Delete("/schedules/:id", func(c *fiber.Ctx) error {
+		if e := schedules.Remove(c.Params("id")); e != nil {
+			return c.Status(scheduleStatus(e)).JSON(fiber.Map{"error": e.Error()})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	api.Post("/schedules/:id/:action", func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if e := scheduleAction(schedules, id, c.Params("action")); e != nil {
+			return c.Status(scheduleStatus(e)).JSON(fiber.Map{"error": e.Error()})
+		}
+		view, _ := schedules.Get(id)
+		return c.JSON(view)
 	})
 	log.Println("web is started")
 	err := app.Listen(fmt.Sprintf("%s:%d", host, port))
@@ -2,93 +2,217 @@ package client
 
 import (
 	"asyncProxy/ws/transport"
+	"context"
 	"github.com/lxzan/gws"
 	"github.com/vmihailenco/msgpack/v5"
 	"io"
 	"log"
+	"strconv"
+	"sync"
 	"time"
 )
 
+// frameHandler 数据流和UDP关联都按StreamId接收后续的消息帧
+type frameHandler interface {
+	HandleFrame(frame *transport.Frame) bool
+}
+
 type WebsocketHandler struct {
 	OnCloseSignal chan bool
+
+	streams sync.Map
 }
 
-func (w *WebsocketHandler) OnOpen(_ *gws.Conn) {
+func (w *WebsocketHandler) OnOpen(socket *gws.Conn) {
 	log.Println("websocket connected!")
+	go keepAlive(socket)
 }
 
 func (w *WebsocketHandler) OnClose(_ *gws.Conn, _ error) {
 	log.Println("websocket connection lost!")
+	w.streams.Range(func(_, value any) bool {
+		switch v := value.(type) {
+		case *transport.Stream:
+			v.Abort(transport.ErrStreamClosed)
+		case *transport.Association:
+			v.Abort()
+		}
+		return true
+	})
 	w.OnCloseSignal <- true
 }
 
-func (w *WebsocketHandler) OnPing(_ *gws.Conn, _ []byte) {
+func (w *WebsocketHandler) OnPing(socket *gws.Conn, payload []byte) {
+	// 服务端通过pong中原样返回的时间戳测量RTT
+	if e := socket.WritePong(payload); e != nil {
+		log.Println("pong error:", e)
+	}
+}
+
+func (w *WebsocketHandler) OnPong(_ *gws.Conn, _ []byte) {
 
 }
 
-func (w *WebsocketHandler) OnPong(socket *gws.Conn, _ []byte) {
-	time.Sleep(10 * time.Second)
-	e := socket.WritePing([]byte(time.Now().Format(time.RFC822Z)))
-	if e != nil {
-		log.Println("ping error:", e)
+// keepAlive 定时发送ping, 不能在OnPong中等待, 否则会阻塞读循环
+func keepAlive(socket *gws.Conn) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		e := socket.WritePing([]byte(time.Now().Format(time.RFC822Z)))
+		if e != nil {
+			log.Println("ping error:", e)
+			return
+		}
+		<-ticker.C
 	}
 }
 
 func (w *WebsocketHandler) OnMessage(socket *gws.Conn, message *gws.Message) {
+	defer message.Close()
 	if message.Opcode != gws.OpcodeBinary {
 		log.Println("invalid opcode:", message.Opcode)
 		return
 	}
 
-	var wsRequest transport.WebsocketProxyRequest
-
-	e := msgpack.Unmarshal(message.Bytes(), &wsRequest)
+	frame, e := transport.ReadFrame(message)
 	if e != nil {
 		log.Println("msgpack unmarshal error:", e)
 		return
 	}
-	httpRequest := httpClient.R()
+
+	switch frame.Type {
+	case transport.FrameTypeRequest:
+		var wsRequest transport.WebsocketProxyRequest
+		e := msgpack.Unmarshal(frame.Data, &wsRequest)
+		if e != nil {
+			log.Println("msgpack unmarshal error:", e)
+			return
+		}
+		streamId := frame.StreamId
+		stream := transport.NewStream(streamId, socket, func() {
+			w.streams.Delete(streamId)
+		})
+		w.streams.Store(streamId, stream)
+		go w.handleRequest(socket, &wsRequest, stream)
+	case transport.FrameTypeTunnelOpen:
+		var openRequest transport.TunnelOpenRequest
+		e := msgpack.Unmarshal(frame.Data, &openRequest)
+		if e != nil {
+			log.Println("msgpack unmarshal error:", e)
+			return
+		}
+		streamId := frame.StreamId
+		stream := transport.NewStream(streamId, socket, func() {
+			w.streams.Delete(streamId)
+		})
+		w.streams.Store(streamId, stream)
+		go handleTunnel(socket, &openRequest, stream)
+	case transport.FrameTypeAssociate:
+		var openRequest transport.AssociateRequest
+		e := msgpack.Unmarshal(frame.Data, &openRequest)
+		if e != nil {
+			log.Println("msgpack unmarshal error:", e)
+			return
+		}
+		associationId := frame.StreamId
+		association := transport.NewAssociation(associationId, socket, func() {
+			w.streams.Delete(associationId)
+		})
+		w.streams.Store(associationId, association)
+		go handleAssociation(&openRequest, association)
+	default:
+		value, ok := w.streams.Load(frame.StreamId)
+		if !ok {
+			return
+		}
+		if !value.(frameHandler).HandleFrame(frame) {
+			log.Println("invalid frame type:", frame.Type)
+		}
+	}
+}
+
+func (w *WebsocketHandler) handleRequest(socket *gws.Conn, wsRequest *transport.WebsocketProxyRequest, stream *transport.Stream) {
+	defer stream.Close()
+
+	// 服务端重置流(请求超时或客户端断开)时中止上游请求
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stream.Aborted():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	httpRequest := httpClient.R().SetContext(ctx)
 	for key, value := range wsRequest.Headers {
 		for _, val := range value {
 			httpRequest.SetHeader(key, val)
 		}
 	}
 
-	httpRequest.SetBodyBytes(wsRequest.Body)
+	if wsRequest.ContentLength != 0 {
+		// 流式请求体无法重放, 不能重试; 请求体由stream的生命周期管理, 不允许http客户端关闭
+		httpRequest.SetBody(io.NopCloser(stream))
+		httpRequest.SetRetryCount(0)
+		if wsRequest.ContentLength > 0 {
+			httpRequest.SetHeader("Content-Length", strconv.FormatInt(wsRequest.ContentLength, 10))
+		}
+	}
 
 	response, e := httpRequest.Send(wsRequest.Method, wsRequest.FullUrl)
+	if ctx.Err() != nil {
+		// 请求已被取消, 服务端不再需要响应
+		log.Println("request cancelled:", wsRequest.FullUrl)
+		if e == nil {
+			_ = response.Body.Close()
+		}
+		return
+	}
 
-	wsResponse := &transport.WebsocketProxyResponse{}
+	wsResponse := &transport.WebsocketProxyResponse{
+		RequestId: wsRequest.RequestId,
+		EdgeId:    wsRequest.EdgeId,
+	}
 
 	if e != nil {
 		wsResponse.Success = false
 		wsResponse.ErrorMessage = e.Error()
-	} else {
-		wsResponse.Success = true
-		wsResponse.Headers = response.Header
-		wsResponse.RequestId = wsRequest.RequestId
-		wsResponse.StatusCode = response.StatusCode
-		wsResponse.EdgeId = wsRequest.EdgeId
-
-		defer response.Body.Close()
-		bodyBytes, e := io.ReadAll(response.Body)
-		if e != nil {
-			log.Println("body read error:", e)
-			wsResponse.Success = false
-			wsResponse.ErrorMessage = e.Error()
-			return
-		} else {
-			wsResponse.Body = bodyBytes
-		}
+		wsResponse.EdgeFailure = edgeFailure(e)
+		writeResponseHeader(socket, stream.Id, wsResponse)
+		return
+	}
+	defer response.Body.Close()
+
+	wsResponse.Success = true
+	wsResponse.Headers = response.Header
+	wsResponse.StatusCode = response.StatusCode
+	wsResponse.ContentLength = response.ContentLength
+	if !writeResponseHeader(socket, stream.Id, wsResponse) {
+		return
+	}
+
+	e = transport.CopyAndClose(stream, response.Body)
+	if e != nil {
+		log.Println("body transfer error:", e)
 	}
+}
 
-	wsBytes, e := msgpack.Marshal(&wsResponse)
+func writeResponseHeader(socket *gws.Conn, streamId string, wsResponse *transport.WebsocketProxyResponse) bool {
+	wsBytes, e := msgpack.Marshal(wsResponse)
 	if e != nil {
 		log.Println("serialize response error:", e)
-		return
+		return false
 	}
-	e = socket.WriteMessage(gws.OpcodeBinary, wsBytes)
+	e = transport.WriteFrame(socket, &transport.Frame{
+		Type:     transport.FrameTypeResponse,
+		StreamId: streamId,
+		Data:     wsBytes,
+	})
 	if e != nil {
 		log.Println("send response error:", e)
+		return false
 	}
+	return true
 }
@@ -1,16 +1,52 @@
 package client
 
 import (
+	goerrors "errors"
 	"github.com/imroc/req/v3"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
 	"time"
 )
 
-var httpClient = req.NewClient().
-	DisableAutoReadResponse().
-	DisableCompression().
-	DisableAutoDecode().
-	EnableHTTP3().
-	SetRedirectPolicy(req.NoRedirectPolicy()).
-	SetCommonRetryCount(5).
-	SetTimeout(60 * time.Second).
-	SetCookieJar(nil)
+var httpClient = newHttpClient()
+
+func newHttpClient() *req.Client {
+	client := req.NewClient().
+		DisableAutoReadResponse().
+		DisableCompression().
+		DisableAutoDecode().
+		EnableHTTP3().
+		SetRedirectPolicy(req.NoRedirectPolicy()).
+		SetCommonRetryCount(5).
+		SetCookieJar(nil)
+	// 响应体是流式转发的, 总超时会中断大文件下载, 这里只限制等待响应头的时间
+	client.GetTransport().
+		SetResponseHeaderTimeout(60 * time.Second).
+		WrapRoundTripFunc(applyContentLength)
+	return client
+}
+
+// applyContentLength req无法推断流式请求体的长度, 根据Content-Length请求头补全, 避免以chunked方式上传
+func applyContentLength(rt http.RoundTripper) req.HttpRoundTripFunc {
+	return func(request *http.Request) (*http.Response, error) {
+		if request.Body != nil && request.ContentLength == 0 {
+			length, e := strconv.ParseInt(request.Header.Get("Content-Length"), 10, 64)
+			if e == nil && length > 0 {
+				request.ContentLength = length
+			}
+		}
+		return rt.RoundTrip(request)
+	}
+}
+
+// edgeFailure 判断请求失败是否由本机网络引起. 域名不存在、连接被拒绝、TLS失败等是目标的问题,
+// DNS服务器无响应、网络不可达说明本节点的网络有问题
+func edgeFailure(e error) bool {
+	var dnsError *net.DNSError
+	if goerrors.As(e, &dnsError) {
+		return !dnsError.IsNotFound
+	}
+	return goerrors.Is(e, syscall.ENETUNREACH) || goerrors.Is(e, syscall.ENETDOWN) || goerrors.Is(e, syscall.EADDRNOTAVAIL)
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	goerrors "errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+)
+
+func TestEdgeFailure(t *testing.T) {
+	dial := func(e error) error {
+		return &url.Error{Op: "Get", URL: "https://example.com", Err: &net.OpError{Op: "dial", Net: "tcp", Err: e}}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no such host", dial(&net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}), false},
+		{"dns timeout", dial(&net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true}), true},
+		{"dns server failure", dial(&net.DNSError{Err: "server misbehaving", Name: "example.com", IsTemporary: true}), true},
+		{"network unreachable", dial(fmt.Errorf("connect: %w", syscall.ENETUNREACH)), true},
+		{"network down", dial(syscall.ENETDOWN), true},
+		{"connection refused", dial(syscall.ECONNREFUSED), false},
+		{"tls", goerrors.New("tls: failed to verify certificate"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := edgeFailure(tt.err); got != tt.want {
+				t.Fatalf("edgeFailure(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"asyncProxy/ws/transport"
+	"github.com/lxzan/gws"
+	"log"
+	"net"
+	"time"
+)
+
+// handleTunnel 连接目标地址并在流和连接之间原样转发字节
+func handleTunnel(socket *gws.Conn, openRequest *transport.TunnelOpenRequest, stream *transport.Stream) {
+	defer stream.Close()
+
+	network := openRequest.Network
+	if network == "" {
+		network = "tcp"
+	}
+	conn, e := net.DialTimeout(network, openRequest.Address, time.Duration(openRequest.Timeout*float64(time.Second)))
+	if e != nil {
+		log.Println("tunnel dial error:", e)
+		_ = stream.Reset(e)
+		return
+	}
+	defer conn.Close()
+
+	e = transport.WriteFrame(socket, &transport.Frame{
+		Type:     transport.FrameTypeTunnelOpened,
+		StreamId: stream.Id,
+	})
+	if e != nil {
+		log.Println("send tunnel opened error:", e)
+		return
+	}
+
+	transport.Relay(conn, conn, stream)
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"asyncProxy/ws/transport"
+	"log"
+	"net"
+	"time"
+)
+
+// maxResolvedAddresses 单个UDP关联缓存的目标地址数量上限
+const maxResolvedAddresses = 1024
+
+// handleAssociation 使用本机的UDP端口收发服务端转发过来的数据报
+func handleAssociation(openRequest *transport.AssociateRequest, association *transport.Association) {
+	defer association.Close()
+
+	udpConn, e := net.ListenUDP("udp", nil)
+	if e != nil {
+		log.Println("udp listen error:", e)
+		return
+	}
+	defer udpConn.Close()
+
+	go association.CloseWhenIdle(time.Duration(openRequest.IdleTimeout * float64(time.Second)))
+	go func() {
+		<-association.Done()
+		_ = udpConn.Close()
+	}()
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, addr, e := udpConn.ReadFromUDP(buf)
+			if e != nil {
+				_ = association.Close()
+				return
+			}
+			e = association.Send(&transport.Datagram{
+				Address: addr.String(),
+				Payload: buf[:n],
+			})
+			if e != nil {
+				return
+			}
+		}
+	}()
+
+	resolved := make(map[string]*net.UDPAddr)
+	for {
+		select {
+		case <-association.Done():
+			return
+		case datagram := <-association.Datagrams():
+			addr, ok := resolved[datagram.Address]
+			if !ok {
+				addr, e = net.ResolveUDPAddr("udp", datagram.Address)
+				if e != nil {
+					log.Println("udp resolve error:", e)
+					continue
+				}
+				if len(resolved) >= maxResolvedAddresses {
+					clear(resolved)
+				}
+				resolved[datagram.Address] = addr
+			}
+			if _, e := udpConn.WriteToUDP(datagram.Payload, addr); e != nil {
+				log.Println("udp write error:", e)
+			}
+		}
+	}
+}
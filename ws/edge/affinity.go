@@ -0,0 +1,121 @@
+package edge
+
+import (
+	"log"
+	"slices"
+	"time"
+)
+
+// DefaultAffinityTTL 会话固定的默认有效期
+const DefaultAffinityTTL = 10 * time.Minute
+
+// DispatchOptions 分发请求时的路由选项, 为nil时使用默认策略
+type DispatchOptions struct {
+	// SessionKey 会话键, 不为空时同一个会话的请求固定发往同一个边缘节点
+	SessionKey string
+	// Labels 只使用带有全部这些标签的节点
+	Labels map[string]string
+	// ExcludeEdges 不使用这些节点, 重试时排除已经尝试过的节点
+	ExcludeEdges []string
+	// Priority 节点都繁忙时的排队优先级
+	Priority Priority
+
+	// noWait 节点都繁忙时不排队, 直接返回错误
+	noWait bool
+}
+
+func (o *DispatchOptions) sessionKey() string {
+	if o == nil {
+		return ""
+	}
+	return o.SessionKey
+}
+
+func (o *DispatchOptions) labels() map[string]string {
+	if o == nil {
+		return nil
+	}
+	return o.Labels
+}
+
+func (o *DispatchOptions) excluded(edgeId string) bool {
+	return o != nil && slices.Contains(o.ExcludeEdges, edgeId)
+}
+
+type affinityPin struct {
+	EdgeId    string
+	ExpiresAt time.Time
+}
+
+// affinity 会话键到边缘节点的固定关系, 由EdgeSet的锁保护
+type affinity struct {
+	ttl     time.Duration
+	pins    map[string]*affinityPin
+	sweptAt time.Time
+}
+
+func newAffinity(ttl time.Duration) *affinity {
+	if ttl <= 0 {
+		ttl = DefaultAffinityTTL
+	}
+	return &affinity{
+		ttl:     ttl,
+		pins:    map[string]*affinityPin{},
+		sweptAt: time.Now(),
+	}
+}
+
+// lookup 返回会话固定的节点并续期, 未固定、已过期或节点已下线时返回nil
+func (a *affinity) lookup(key string, edges []*Edge) *Edge {
+	pin, ok := a.pins[key]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	if now.After(pin.ExpiresAt) {
+		delete(a.pins, key)
+		return nil
+	}
+	for _, edge := range edges {
+		if edge.EdgeId == pin.EdgeId {
+			pin.ExpiresAt = now.Add(a.ttl)
+			return edge
+		}
+	}
+	return nil
+}
+
+func (a *affinity) pin(key, edgeId string) {
+	now := time.Now()
+	a.pins[key] = &affinityPin{EdgeId: edgeId, ExpiresAt: now.Add(a.ttl)}
+	if now.Sub(a.sweptAt) < a.ttl {
+		return
+	}
+	a.sweptAt = now
+	for k, pin := range a.pins {
+		if now.After(pin.ExpiresAt) {
+			delete(a.pins, k)
+		}
+	}
+}
+
+// unpin 解除固定在已下线节点上的会话, 会话的下一个请求按标签、熔断和并发名额重新选择节点并固定
+func (a *affinity) unpin(edgeId string) {
+	count := 0
+	for key, pin := range a.pins {
+		if pin.EdgeId == edgeId {
+			delete(a.pins, key)
+			count++
+		}
+	}
+	if count > 0 {
+		log.Println("节点下线, 解除固定的会话数为:", count)
+	}
+}
+
+// SetAffinityTTL 设置会话固定的有效期, 每次命中会续期
+func (s *EdgeSet) SetAffinityTTL(ttl time.Duration) {
+	s.RWMutex.Lock()
+	defer s.RWMutex.Unlock()
+	s.affinity = newAffinity(ttl)
+}
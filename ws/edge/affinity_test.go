@@ -0,0 +1,126 @@
+package edge
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAffinity(t *testing.T) {
+	now := time.Now()
+	a, b, c := testEdge("a", 0, 0, 0, now), testEdge("b", 0, 0, 0, now), testEdge("c", 0, 0, 0, now)
+	tests := []struct {
+		name string
+		// run 对固定了 s1->a 的affinity操作, 返回之后查询s1的结果
+		run  func(aff *affinity) *Edge
+		want *Edge
+	}{
+		{"pinned", func(aff *affinity) *Edge {
+			return aff.lookup("s1", []*Edge{b, a})
+		}, a},
+		{"unknown session", func(aff *affinity) *Edge {
+			return aff.lookup("s2", []*Edge{a, b})
+		}, nil},
+		{"pinned edge not a candidate", func(aff *affinity) *Edge {
+			return aff.lookup("s1", []*Edge{b, c})
+		}, nil},
+		{"unpin after disconnect", func(aff *affinity) *Edge {
+			aff.unpin("a")
+			return aff.lookup("s1", []*Edge{a, b})
+		}, nil},
+		{"repin after unpin", func(aff *affinity) *Edge {
+			aff.unpin("a")
+			aff.pin("s1", "c")
+			return aff.lookup("s1", []*Edge{b, c})
+		}, c},
+		{"other edge disconnect keeps pin", func(aff *affinity) *Edge {
+			aff.unpin("b")
+			return aff.lookup("s1", []*Edge{a, b, c})
+		}, a},
+		{"expired", func(aff *affinity) *Edge {
+			time.Sleep(80 * time.Millisecond)
+			return aff.lookup("s1", []*Edge{a})
+		}, nil},
+		{"lookup renews ttl", func(aff *affinity) *Edge {
+			for i := 0; i < 4; i++ {
+				time.Sleep(20 * time.Millisecond)
+				if aff.lookup("s1", []*Edge{a}) == nil {
+					return nil
+				}
+			}
+			return aff.lookup("s1", []*Edge{a})
+		}, a},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aff := newAffinity(50 * time.Millisecond)
+			aff.pin("s1", "a")
+			if got := tt.run(aff); got != tt.want {
+				t.Fatalf("got %v, want %v", edgeIdOrNil(got), edgeIdOrNil(tt.want))
+			}
+		})
+	}
+}
+
+func TestAffinitySweep(t *testing.T) {
+	aff := newAffinity(10 * time.Millisecond)
+	aff.pin("s1", "a")
+	time.Sleep(20 * time.Millisecond)
+	// 超过有效期后再次固定时清理过期的会话
+	aff.pin("s2", "b")
+	if _, ok := aff.pins["s1"]; ok {
+		t.Fatal("expired pin not swept")
+	}
+	if _, ok := aff.pins["s2"]; !ok {
+		t.Fatal("new pin missing")
+	}
+}
+
+func edgeIdOrNil(edge *Edge) string {
+	if edge == nil {
+		return "<nil>"
+	}
+	return edge.EdgeId
+}
+
+func TestAffinityAfterRemove(t *testing.T) {
+	now := time.Now()
+	a, b, c := testEdge("a", 0, 0, 0, now), testEdge("b", 0, 0, 0, now), testEdge("c", 0, 0, 0, now)
+	a.Info.Labels = map[string]string{"region": "east"}
+	b.Info.Labels = map[string]string{"region": "west"}
+	c.Info.Labels = map[string]string{"region": "east"}
+	c.Info.MaxInFlight = 1
+	s := NewEdgeSet()
+	addEdges(s, a, b, c)
+	options := &DispatchOptions{SessionKey: "s1", Labels: map[string]string{"region": "east"}}
+
+	edge, e := s.pickEdge(context.Background(), options)
+	if e != nil || edge != a {
+		t.Fatalf("first pick = %v, %v", edgeIdOrNil(edge), e)
+	}
+	s.release(edge)
+	s.Remove("a")
+	s.Lock()
+	_, pinned := s.affinity.pins["s1"]
+	s.Unlock()
+	if pinned {
+		t.Fatal("session still pinned to removed edge")
+	}
+
+	// 重新选择时仍然遵守标签, 并固定到新的节点
+	for i := 0; i < 3; i++ {
+		edge, e = s.pickEdge(context.Background(), options)
+		if e != nil || edge != c {
+			t.Fatalf("pick #%d after remove = %v, %v", i, edgeIdOrNil(edge), e)
+		}
+		s.release(edge)
+	}
+	// 固定的节点繁忙时等待而不是换到其他节点
+	busy, _ := s.pickEdge(context.Background(), options)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if edge, e := s.pickEdge(ctx, options); e == nil {
+		t.Fatalf("picked %s while pinned edge busy", edge.EdgeId)
+	}
+	s.release(busy)
+}
@@ -0,0 +1,60 @@
+package edge
+
+import (
+	"asyncProxy/constant/errcode"
+	"asyncProxy/errors"
+	"asyncProxy/ws/transport"
+	"context"
+	"github.com/lxzan/gws"
+	"github.com/oklog/ulid/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"time"
+)
+
+// OpenAssociation 在边缘节点上打开UDP关联, 超过idleTimeout没有数据报时两端都会关闭
+func (s *EdgeSet) OpenAssociation(idleTimeout time.Duration, options *DispatchOptions) (*transport.Association, string, error) {
+	firstEdge, e := s.pickEdge(context.Background(), options)
+	if e != nil {
+		return nil, "", e
+	}
+
+	associationId := ulid.Make().String()
+	b, e := msgpack.Marshal(&transport.AssociateRequest{
+		IdleTimeout: idleTimeout.Seconds(),
+		EdgeId:      firstEdge.EdgeId,
+	})
+	if e != nil {
+		s.release(firstEdge)
+		return nil, "", errors.NewBusinessError(errcode.ErrorSerializeOrDeserializeFailed, "UDP关联请求序列化失败").WithInnerError(e)
+	}
+
+	association := transport.NewAssociation(associationId, firstEdge.Conn, func() {
+		s.associations.Delete(associationId)
+		s.release(firstEdge)
+	})
+	s.associations.Store(associationId, association)
+
+	e = transport.WriteFrame(firstEdge.Conn, &transport.Frame{
+		Type:     transport.FrameTypeAssociate,
+		StreamId: associationId,
+		Data:     b,
+	})
+	if e != nil {
+		association.Abort()
+		return nil, "", errors.NewBusinessError(errcode.ErrorEdgeSendMessageFailed, "发送UDP关联请求到边缘节点失败").WithInnerError(e)
+	}
+	go association.CloseWhenIdle(idleTimeout)
+	return association, firstEdge.EdgeId, nil
+}
+
+func (s *EdgeSet) loadAssociation(conn *gws.Conn, associationId string) *transport.Association {
+	value, ok := s.associations.Load(associationId)
+	if !ok {
+		return nil
+	}
+	association, ok := value.(*transport.Association)
+	if !ok || association.Conn() != conn {
+		return nil
+	}
+	return association
+}
@@ -0,0 +1,307 @@
+package edge
+
+import (
+	"asyncProxy/constant/errcode"
+	"asyncProxy/errors"
+	"asyncProxy/ws/transport"
+	"bytes"
+	"context"
+	"github.com/oklog/ulid/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 服务端本地生成的失败响应的错误信息
+const (
+	ErrorMessageTimeout          = "Request Timeout"
+	ErrorMessageEdgeDisconnected = "Edge Disconnected"
+	ErrorMessageCancelled        = "Request Cancelled"
+)
+
+type OnResponseCompleteCallback func(response *transport.WebsocketProxyResponse)
+type OnResponseTimeoutCallback func(requestId string)
+
+// Request 分发到边缘节点的HTTP请求
+type Request struct {
+	Method  string
+	Url     string
+	Headers map[string][]string
+	// Body 请求体, 为nil表示没有请求体. 可以重复读取(实现io.ReaderAt和Size, 如*bytes.Reader)时才会对冲
+	Body io.Reader
+	// Timeout 等待响应头的超时, 为0时使用ctx的截止时间, 都没有时使用DefaultAttemptTimeout
+	Timeout time.Duration
+	Options *DispatchOptions
+}
+
+type requestCallback struct {
+	RequestId    string
+	EdgeId       string
+	Complete     OnResponseCompleteCallback
+	CompleteChan chan struct{}
+
+	edge     *Edge
+	startAt  time.Time
+	once     sync.Once
+	timer    atomic.Pointer[time.Timer]
+	response *transport.WebsocketProxyResponse
+}
+
+// finish 保证请求只完成一次, 返回本次调用是否完成了请求. CompleteChan关闭后response可读
+func (c *requestCallback) finish(response *transport.WebsocketProxyResponse) bool {
+	fired := false
+	c.once.Do(func() {
+		fired = true
+		if timer := c.timer.Load(); timer != nil {
+			timer.Stop()
+		}
+		c.response = response
+		close(c.CompleteChan)
+		if c.Complete != nil {
+			c.Complete(response)
+		}
+	})
+	return fired
+}
+
+// DispatchRequestContext 分发请求并等待响应头, 响应体通过response.BodyReader读取.
+// 超时返回失败的响应, ctx结束时取消请求并通知边缘节点中止, 返回ctx.Err()
+func (s *EdgeSet) DispatchRequestContext(ctx context.Context, request *Request) (*transport.WebsocketProxyResponse, error) {
+	// 会话固定的请求必须走同一个节点, 不能对冲
+	if policy := s.hedgePolicy.Load(); policy.enabled(request.Method) && request.Options.sessionKey() == "" {
+		if body, ok := replayable(request.Body); ok {
+			return s.dispatchHedged(ctx, request, body, policy)
+		}
+	}
+	c, e := s.send(ctx, request, nil)
+	if e != nil {
+		return nil, e
+	}
+	return s.wait(ctx, c)
+}
+
+// DispatchRequestAsync 将请求分发到边缘节点, 请求体通过数据帧流式发送.
+// 回调在收到响应头时触发, 响应体通过response.BodyReader读取, 回调中不应阻塞.
+func (s *EdgeSet) DispatchRequestAsync(request *Request, completeCallback OnResponseCompleteCallback) (reqId string, err error) {
+	c, e := s.send(context.Background(), request, completeCallback)
+	if e != nil {
+		return "", e
+	}
+	return c.RequestId, nil
+}
+
+// DispatchRequest 将请求分发到边缘节点, 回调收到的响应已读完响应体(response.Body).
+// 保留旧的调用方式, 新代码应使用DispatchRequestAsync或DispatchRequestContext
+func (s *EdgeSet) DispatchRequest(method, url string, headers map[string][]string, body []byte, timeout time.Duration,
+	completeCallback OnResponseCompleteCallback) (reqId string, err error) {
+	return s.DispatchRequestAsync(bytesRequest(method, url, headers, body, timeout), func(response *transport.WebsocketProxyResponse) {
+		if response.BodyReader == nil {
+			completeCallback(response)
+			return
+		}
+		// 响应体的数据帧由收到响应头的协程处理, 不能在这里等待
+		go func() {
+			readResponseBody(response)
+			completeCallback(response)
+		}()
+	})
+}
+
+// DispatchRequestAndWait 分发请求并等待响应, 返回的响应已读完响应体(response.Body).
+// 保留旧的调用方式, 新代码应使用DispatchRequestContext
+func (s *EdgeSet) DispatchRequestAndWait(method, url string, headers map[string][]string, body []byte,
+	timeout time.Duration) (response *transport.WebsocketProxyResponse, err error) {
+	response, e := s.DispatchRequestContext(context.Background(), bytesRequest(method, url, headers, body, timeout))
+	if e != nil {
+		return nil, e
+	}
+	readResponseBody(response)
+	return response, nil
+}
+
+// bytesRequest 旧接口的请求, 请求体为空表示没有请求体, 没有声明长度时补上Content-Length
+func bytesRequest(method, url string, headers map[string][]string, body []byte, timeout time.Duration) *Request {
+	request := &Request{Method: method, Url: url, Headers: headers, Timeout: timeout}
+	if len(body) == 0 {
+		return request
+	}
+	request.Body = bytes.NewReader(body)
+	if http.Header(headers).Get("Content-Length") == "" {
+		header := http.Header(headers).Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+		request.Headers = header
+	}
+	return request
+}
+
+// readResponseBody 把流式响应体读入response.Body, 读取失败时响应标记为失败
+func readResponseBody(response *transport.WebsocketProxyResponse) {
+	if response.BodyReader == nil {
+		return
+	}
+	body, e := io.ReadAll(response.BodyReader)
+	_ = response.BodyReader.Close()
+	response.BodyReader = nil
+	response.Body = body
+	if e != nil {
+		response.Success = false
+		response.ErrorMessage = e.Error()
+	}
+}
+
+// send 选出节点并发送请求, 超时由定时器完成请求, 不额外占用协程
+func (s *EdgeSet) send(ctx context.Context, request *Request, complete OnResponseCompleteCallback) (*requestCallback, error) {
+	if e := ctx.Err(); e != nil {
+		return nil, e
+	}
+	firstEdge, e := s.pickEdge(ctx, request.Options)
+	if e != nil {
+		return nil, e
+	}
+
+	timeout := requestTimeout(ctx, request.Timeout)
+	requestId := ulid.Make().String()
+	contentLength := requestContentLength(request.Headers, request.Body)
+	wsRequest := transport.WebsocketProxyRequest{
+		FullUrl:       request.Url,
+		Headers:       request.Headers,
+		RequestId:     requestId,
+		Method:        request.Method,
+		Timeout:       timeout.Seconds(),
+		EdgeId:        firstEdge.EdgeId,
+		ContentLength: contentLength,
+	}
+
+	b, e := msgpack.Marshal(&wsRequest)
+	if e != nil {
+		s.release(firstEdge)
+		return nil, errors.NewBusinessError(errcode.ErrorSerializeOrDeserializeFailed, "HTTP请求序列化失败").WithInnerError(e)
+	}
+
+	stream := transport.NewStream(requestId, firstEdge.Conn, func() {
+		s.streams.Delete(requestId)
+		s.release(firstEdge)
+	})
+	s.streams.Store(requestId, stream)
+
+	c := &requestCallback{
+		RequestId:    requestId,
+		EdgeId:       firstEdge.EdgeId,
+		Complete:     complete,
+		CompleteChan: make(chan struct{}),
+		edge:         firstEdge,
+		startAt:      time.Now(),
+	}
+	// 先登记再启动定时器, 否则超时很短时expire可能在登记之前删除回调, 回调会一直留在callbacks中
+	s.callbacks.Store(requestId, c)
+	c.timer.Store(time.AfterFunc(timeout, func() {
+		s.expire(c, stream, timeout)
+	}))
+
+	e = transport.WriteFrame(firstEdge.Conn, &transport.Frame{
+		Type:     transport.FrameTypeRequest,
+		StreamId: requestId,
+		Data:     b,
+	})
+	if e != nil {
+		c.timer.Load().Stop()
+		s.callbacks.Delete(requestId)
+		stream.Abort(e)
+		return nil, errors.NewBusinessError(errcode.ErrorEdgeSendMessageFailed, "发送请求到边缘节点失败").WithInnerError(e)
+	}
+
+	if contentLength != 0 {
+		go func() {
+			if e := transport.CopyAndClose(stream, request.Body); e != nil {
+				log.Println("send request body error:", e)
+			}
+		}()
+	} else {
+		_ = stream.CloseWrite()
+	}
+	return c, nil
+}
+
+// expire 请求超时, 回调收到超时的响应并通知边缘节点中止
+func (s *EdgeSet) expire(c *requestCallback, stream *transport.Stream, timeout time.Duration) {
+	timeoutResponse := transport.WebsocketProxyResponse{
+		Success:      false,
+		ErrorMessage: ErrorMessageTimeout,
+		RequestId:    c.RequestId,
+		StatusCode:   -1,
+		EdgeId:       c.EdgeId,
+	}
+	if c.finish(&timeoutResponse) {
+		s.callbacks.Delete(c.RequestId)
+		_ = stream.Close()
+		s.recordOutcome(c.edge, outcome{timeout: true, latency: timeout})
+	}
+}
+
+// wait 等待请求完成, ctx结束时取消请求
+func (s *EdgeSet) wait(ctx context.Context, c *requestCallback) (*transport.WebsocketProxyResponse, error) {
+	select {
+	case <-c.CompleteChan:
+		return c.response, nil
+	case <-ctx.Done():
+		s.CancelRequest(c.RequestId)
+		// 取消的同时可能已经收到了响应
+		<-c.CompleteChan
+		if c.response.BodyReader != nil {
+			_ = c.response.BodyReader.Close()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// requestTimeout 未指定超时时使用ctx的截止时间
+func requestTimeout(ctx context.Context, timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		return max(time.Until(deadline), time.Millisecond)
+	}
+	return DefaultAttemptTimeout
+}
+
+// requestContentLength 根据请求头推断请求体长度, 0表示没有请求体, -1表示未知
+func requestContentLength(headers map[string][]string, body io.Reader) int64 {
+	if body == nil || body == http.NoBody {
+		return 0
+	}
+	if value := http.Header(headers).Get("Content-Length"); value != "" {
+		if length, e := strconv.ParseInt(value, 10, 64); e == nil {
+			return length
+		}
+	}
+	return -1
+}
+
+// CancelRequest 取消尚未收到响应头的请求并通知边缘节点重置, 回调收到取消的响应
+func (s *EdgeSet) CancelRequest(requestId string) bool {
+	value, ok := s.callbacks.LoadAndDelete(requestId)
+	if !ok {
+		return false
+	}
+	c := value.(*requestCallback)
+	fired := c.finish(&transport.WebsocketProxyResponse{
+		Success:      false,
+		ErrorMessage: ErrorMessageCancelled,
+		RequestId:    requestId,
+		StatusCode:   -1,
+		EdgeId:       c.EdgeId,
+	})
+	if value, ok := s.streams.Load(requestId); ok && fired {
+		_ = value.(*transport.Stream).Close()
+	}
+	return fired
+}
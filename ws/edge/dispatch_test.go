@@ -0,0 +1,101 @@
+package edge
+
+import (
+	"asyncProxy/ws/transport"
+	"bytes"
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// echo 以请求体作为响应体
+func echo(request *transport.WebsocketProxyRequest, body []byte) fakeReply {
+	return fakeReply{status: http.StatusOK, body: body}
+}
+
+func TestDispatchRequest(t *testing.T) {
+	s := NewEdgeSet()
+	fake := addFakeEdge(t, s, EdgeInfo{}, echo)
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"no body", nil},
+		{"body", []byte("hello")},
+		{"multiple chunks", bytes.Repeat([]byte("x"), transport.StreamChunkSize*3+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			responses := make(chan *transport.WebsocketProxyResponse, 2)
+			_, e := s.DispatchRequest(http.MethodPost, "http://example.com", nil, tt.body, time.Second,
+				func(response *transport.WebsocketProxyResponse) {
+					calls.Add(1)
+					responses <- response
+				})
+			if e != nil {
+				t.Fatal(e)
+			}
+			response := <-responses
+			// 旧接口的回调收到完整的响应体
+			if !response.Success || response.BodyReader != nil || !bytes.Equal(response.Body, tt.body) {
+				t.Fatalf("response success=%v body=%d bytes", response.Success, len(response.Body))
+			}
+
+			response, e = s.DispatchRequestAndWait(http.MethodPost, "http://example.com", nil, tt.body, time.Second)
+			if e != nil {
+				t.Fatal(e)
+			}
+			if !response.Success || !bytes.Equal(response.Body, tt.body) {
+				t.Fatalf("wait response success=%v body=%d bytes", response.Success, len(response.Body))
+			}
+			time.Sleep(20 * time.Millisecond)
+			if calls.Load() != 1 {
+				t.Fatalf("callback called %d times", calls.Load())
+			}
+		})
+	}
+	waitIdle(t, fake)
+}
+
+func TestDispatchRequestTimeout(t *testing.T) {
+	s := NewEdgeSet()
+	fake := addFakeEdge(t, s, EdgeInfo{}, func(*transport.WebsocketProxyRequest, []byte) fakeReply {
+		return fakeReply{status: http.StatusOK, delay: time.Second}
+	})
+	var calls atomic.Int32
+	done := make(chan *transport.WebsocketProxyResponse, 2)
+	_, e := s.DispatchRequestAsync(&Request{Method: http.MethodGet, Url: "http://example.com", Timeout: 50 * time.Millisecond},
+		func(response *transport.WebsocketProxyResponse) {
+			calls.Add(1)
+			done <- response
+		})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if response := <-done; response.Success || response.ErrorMessage != ErrorMessageTimeout {
+		t.Fatalf("response = %+v", response)
+	}
+	waitIdle(t, fake)
+	eventually(t, "edge not reset", func() bool { return fake.resets.Load() == 1 })
+	time.Sleep(20 * time.Millisecond)
+	if calls.Load() != 1 || fake.resets.Load() != 1 {
+		t.Fatalf("callback called %d times, edge reset %d times", calls.Load(), fake.resets.Load())
+	}
+}
+
+func TestDispatchRequestContextCancel(t *testing.T) {
+	s := NewEdgeSet()
+	fake := addFakeEdge(t, s, EdgeInfo{}, func(*transport.WebsocketProxyRequest, []byte) fakeReply {
+		return fakeReply{status: http.StatusOK, delay: time.Second}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, e := s.DispatchRequestContext(ctx, &Request{Method: http.MethodGet, Url: "http://example.com", Timeout: time.Second}); e != context.DeadlineExceeded {
+		t.Fatalf("error = %v", e)
+	}
+	waitIdle(t, fake)
+	eventually(t, "edge not reset", func() bool { return fake.resets.Load() == 1 })
+}
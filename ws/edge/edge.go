@@ -5,41 +5,105 @@ import (
 	"asyncProxy/constant/errcode"
 	"asyncProxy/errors"
 	"asyncProxy/ws/transport"
-	"cmp"
+	"container/list"
 	"github.com/lxzan/gws"
 	"github.com/oklog/ulid/v2"
-	"github.com/vmihailenco/msgpack/v5"
 	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type OnResponseCompleteCallback func(response *transport.WebsocketProxyResponse)
-type OnResponseTimeoutCallback func(requestId string)
-
-type requestCallback struct {
-	Complete     OnResponseCompleteCallback
-	CompleteChan chan int
+// EdgeInfo 边缘节点在握手时声明的信息
+type EdgeInfo struct {
+	// Weight 按权重选择节点时使用, 未声明时为1
+	Weight int
+	// MaxInFlight 同时处理的请求、隧道和UDP关联数上限, 0为不限制
+	MaxInFlight int
+	// Labels 节点标签, 如 region=cn-east、isp=telecom
+	Labels map[string]string
 }
 
 type Edge struct {
 	Conn       *gws.Conn
 	EdgeId     string
 	LastUsedAt time.Time
+	Info       EdgeInfo
+	// InFlight 正在处理的请求、隧道和UDP关联数量
+	InFlight atomic.Int64
+
+	rtt    atomic.Int64
+	health health
+}
+
+// Rtt 心跳往返时间的平滑值, 尚未测得时为0
+func (e *Edge) Rtt() time.Duration {
+	return time.Duration(e.rtt.Load())
+}
+
+// Match 判断节点是否带有selector中的全部标签
+func (e *Edge) Match(selector map[string]string) bool {
+	for key, value := range selector {
+		if label, ok := e.Info.Labels[key]; !ok || label != value {
+			return false
+		}
+	}
+	return true
+}
+
+// idle 节点是否还有空闲的并发名额
+func (e *Edge) idle() bool {
+	return e.Info.MaxInFlight <= 0 || e.InFlight.Load() < int64(e.Info.MaxInFlight)
+}
+
+func (e *Edge) weight() int {
+	if e.Info.Weight <= 0 {
+		return 1
+	}
+	return e.Info.Weight
 }
 
 type EdgeSet struct {
-	edges     []*Edge
-	callbacks sync.Map
+	edges        []*Edge
+	selector     Selector
+	affinity     *affinity
+	healthPolicy atomic.Pointer[HealthPolicy]
+	hedgePolicy  atomic.Pointer[HedgePolicy]
+	latencies    latencies
+	queue        *list.List
+	queueSize    int
+	queueTimeout time.Duration
+	edgeGrace    time.Duration
+	// edgeWaitSize 等待节点上线的请求数上限, 与queueSize分开计算
+	edgeWaitSize int
+	// edgeWaiters 队列中等待节点上线的请求数
+	edgeWaiters int
+	// priorityAging 排队请求优先级提升的间隔
+	priorityAging time.Duration
+	callbacks     sync.Map
+	streams       sync.Map
+	tunnels       sync.Map
+	associations  sync.Map
 
 	sync.RWMutex // for safely operate edges
 }
 
 func NewEdgeSet() *EdgeSet {
 	return &EdgeSet{
-		edges:     []*Edge{},
-		callbacks: sync.Map{},
-		RWMutex:   sync.RWMutex{},
+		edges:         []*Edge{},
+		selector:      &RoundRobinSelector{},
+		affinity:      newAffinity(DefaultAffinityTTL),
+		queue:         list.New(),
+		queueSize:     DefaultQueueSize,
+		queueTimeout:  DefaultQueueTimeout,
+		edgeWaitSize:  DefaultQueueSize,
+		priorityAging: DefaultPriorityAging,
+		callbacks:     sync.Map{},
+		streams:       sync.Map{},
+		tunnels:       sync.Map{},
+		associations:  sync.Map{},
+		RWMutex:       sync.RWMutex{},
 	}
 }
 
@@ -49,18 +113,27 @@ func (s *EdgeSet) Len() int {
 	return len(s.edges)
 }
 
-func (s *EdgeSet) Add(conn *gws.Conn) string {
+// SetSelector 设置节点选择策略
+func (s *EdgeSet) SetSelector(selector Selector) {
+	s.RWMutex.Lock()
+	defer s.RWMutex.Unlock()
+	s.selector = selector
+}
+
+func (s *EdgeSet) Add(conn *gws.Conn, info EdgeInfo) string {
 	edgeId := ulid.Make().String()
 	conn.Session().Store(constant.ConnSessionEdgeId, edgeId)
 	edge := &Edge{
 		Conn:       conn,
 		EdgeId:     edgeId,
 		LastUsedAt: time.Unix(0, 0),
+		Info:       info,
 	}
 	s.RWMutex.Lock()
 	defer s.RWMutex.Unlock()
 
 	s.edges = append(s.edges, edge)
+	s.serveWaitersLocked()
 	return edgeId
 }
 
@@ -71,148 +144,93 @@ func (s *EdgeSet) Remove(edgeId string) {
 		return edge.EdgeId == edgeId
 	})
 	s.edges = newSlice
+	s.affinity.unpin(edgeId)
 }
 
 func (s *EdgeSet) RemoveByConnection(conn *gws.Conn) (err error) {
-	var edgeId string
-	if anyEdgeId, exists := conn.Session().Load(constant.ConnSessionEdgeId); exists {
-		if eid, ok := anyEdgeId.(string); ok {
-			edgeId = eid
-		} else {
-			return errors.NewBusinessError(errcode.ErrorInvalidEdgeId, "边缘节点ID类型错误")
-		}
-	} else {
-		return errors.NewBusinessError(errcode.ErrorNoEdgeIdDefined, "边缘节点ID未定义")
-	}
-
-	s.Remove(edgeId)
-
-	return nil
-}
-
-func (s *EdgeSet) DispatchRequest(method, url string, headers map[string][]string, body []byte, timeout time.Duration,
-	completeCallback OnResponseCompleteCallback) (reqId string, err error) {
-	if len(s.edges) == 0 {
-		return "", errors.NewBusinessError(errcode.ErrorNoEdge, "边缘节点为空")
-	}
-	s.RWMutex.RLock()
-	defer s.RWMutex.RUnlock()
-	sortAndReturnEdge := func() *Edge {
-		copyEdges := slices.Clone(s.edges)
-		slices.SortFunc(copyEdges, func(a, b *Edge) int {
-			return cmp.Compare(a.LastUsedAt.Unix(), b.LastUsedAt.Unix())
-		})
-		firstEdge := s.edges[0]
-		firstEdge.LastUsedAt = time.Now()
-		return firstEdge
-	}
-
-	firstEdge := sortAndReturnEdge()
-
-	requestId := ulid.Make().String()
-	request := transport.WebsocketProxyRequest{
-		FullUrl:   url,
-		Headers:   headers,
-		Body:      body,
-		RequestId: requestId,
-		Method:    method,
-		Timeout:   timeout.Seconds(),
-		EdgeId:    firstEdge.EdgeId,
-	}
-
-	b, e := msgpack.Marshal(&request)
+	edgeId, e := edgeIdOf(conn)
 	if e != nil {
-		return "", errors.NewBusinessError(errcode.ErrorSerializeOrDeserializeFailed, "HTTP请求序列化失败").WithInnerError(e)
+		return e
 	}
-	e = firstEdge.Conn.WriteMessage(gws.OpcodeBinary, b)
 
-	if e != nil {
-		return "", errors.NewBusinessError(errcode.ErrorEdgeSendMessageFailed, "发送请求到边缘节点失败").WithInnerError(e)
-	}
-	completeChan := make(chan int)
-
-	callbackStruct := requestCallback{
-		Complete:     completeCallback,
-		CompleteChan: completeChan,
-	}
-
-	s.callbacks.Store(requestId, &callbackStruct)
+	s.Remove(edgeId)
 
-	go func() {
-		timeoutTimer := time.After(timeout)
-		select {
-		case <-timeoutTimer:
-			timeoutResponse := transport.WebsocketProxyResponse{
+	// 该节点上未完成的请求和流立即失败, 不再等待超时
+	s.callbacks.Range(func(key, value any) bool {
+		if c, ok := value.(*requestCallback); ok && c.EdgeId == edgeId {
+			c.finish(&transport.WebsocketProxyResponse{
 				Success:      false,
-				ErrorMessage: "Request Timeout",
-				Headers:      nil,
-				Body:         nil,
-				RequestId:    requestId,
+				ErrorMessage: ErrorMessageEdgeDisconnected,
+				RequestId:    c.RequestId,
 				StatusCode:   -1,
-				EdgeId:       firstEdge.EdgeId,
-			}
-			completeCallback(&timeoutResponse)
-			s.callbacks.Delete(requestId)
-		case <-completeChan:
-			s.callbacks.Delete(requestId)
+				EdgeId:       edgeId,
+			})
+			s.callbacks.Delete(key)
 		}
-	}()
-	return requestId, nil
-}
-
-func (s *EdgeSet) DispatchRequestAndWait(method, url string, headers map[string][]string, body []byte, timeout time.Duration) (response *transport.WebsocketProxyResponse, err error) {
-	messageChan := make(chan *transport.WebsocketProxyResponse)
+		return true
+	})
+	s.tunnels.Range(func(_, value any) bool {
+		if c, ok := value.(*tunnelCallback); ok && c.EdgeId == edgeId {
+			c.resolve(errors.NewBusinessError(errcode.ErrorEdgeDisconnected, "边缘节点连接断开"))
+		}
+		return true
+	})
+	s.streams.Range(func(_, value any) bool {
+		if stream, ok := value.(*transport.Stream); ok && stream.Conn() == conn {
+			stream.Abort(errors.NewBusinessError(errcode.ErrorEdgeDisconnected, "边缘节点连接断开"))
+		}
+		return true
+	})
+	s.associations.Range(func(_, value any) bool {
+		if association, ok := value.(*transport.Association); ok && association.Conn() == conn {
+			association.Abort()
+		}
+		return true
+	})
 
-	completeCallback := func(response *transport.WebsocketProxyResponse) {
-		messageChan <- response
-	}
+	return nil
+}
 
-	_, e := s.DispatchRequest(method, url, headers, body, timeout, completeCallback)
+// OnPong 根据心跳应答中携带的发送时间更新节点RTT
+func (s *EdgeSet) OnPong(conn *gws.Conn, payload []byte) {
+	sentAt, e := strconv.ParseInt(string(payload), 10, 64)
 	if e != nil {
-		return nil, e
+		return
 	}
-
-	result := <-messageChan
-	return result, nil
-}
-
-func (s *EdgeSet) OnResponse(conn *gws.Conn, message *gws.Message) (response *transport.WebsocketProxyResponse, err error) {
-	defer message.Close()
-	if message.Opcode != gws.OpcodeBinary {
-		return nil, errors.NewBusinessError(errcode.ErrorInvalidResponseMessageType, "错误的消息类型")
+	sample := time.Since(time.Unix(0, sentAt))
+	edge := s.findByConnection(conn)
+	if edge == nil || sample < 0 {
+		return
 	}
-	var edgeId string
-	if anyEdgeId, exists := conn.Session().Load(constant.ConnSessionEdgeId); exists {
-		if eid, ok := anyEdgeId.(string); ok {
-			edgeId = eid
-		} else {
-			return nil, errors.NewBusinessError(errcode.ErrorInvalidEdgeId, "边缘节点ID类型错误")
-		}
+	old := edge.rtt.Load()
+	if old == 0 {
+		edge.rtt.Store(int64(sample))
 	} else {
-		return nil, errors.NewBusinessError(errcode.ErrorNoEdgeIdDefined, "边缘节点ID未定义")
+		edge.rtt.Store((old*7 + int64(sample)) / 8)
 	}
-	messageBytes := message.Bytes()
-	var wsResponse transport.WebsocketProxyResponse
-	e := msgpack.Unmarshal(messageBytes, &wsResponse)
-	if e != nil {
-		return nil, errors.NewBusinessError(errcode.ErrorSerializeOrDeserializeFailed, "边缘节点响应解码失败").WithInnerError(e)
-	}
-
-	wsResponse.EdgeId = edgeId
+}
 
-	callback, _ := s.callbacks.Load(wsResponse.RequestId)
-	if callback != nil {
-		if c, ok := callback.(*requestCallback); ok {
-			c.Complete(&wsResponse)
-			c.CompleteChan <- 0
-			close(c.CompleteChan)
+func (s *EdgeSet) findByConnection(conn *gws.Conn) *Edge {
+	s.RWMutex.RLock()
+	defer s.RWMutex.RUnlock()
+	for _, edge := range s.edges {
+		if edge.Conn == conn {
+			return edge
 		}
-		s.callbacks.Delete(wsResponse.RequestId)
 	}
-
-	return &wsResponse, nil
+	return nil
 }
 
 func (s *EdgeSet) Data() []*Edge {
 	return s.edges
 }
+
+func edgeIdOf(conn *gws.Conn) (string, error) {
+	if anyEdgeId, exists := conn.Session().Load(constant.ConnSessionEdgeId); exists {
+		if eid, ok := anyEdgeId.(string); ok {
+			return eid, nil
+		}
+		return "", errors.NewBusinessError(errcode.ErrorInvalidEdgeId, "边缘节点ID类型错误")
+	}
+	return "", errors.NewBusinessError(errcode.ErrorNoEdgeIdDefined, "边缘节点ID未定义")
+}
@@ -0,0 +1,174 @@
+package edge
+
+import (
+	"asyncProxy/ws/transport"
+	"bytes"
+	"github.com/lxzan/gws"
+	"github.com/vmihailenco/msgpack/v5"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeReply 测试节点对一个请求的响应
+type fakeReply struct {
+	status int
+	body   []byte
+	// delay 发送响应头前等待的时间, 期间被服务端重置时不再响应
+	delay time.Duration
+	// fail 不为空时返回失败的响应
+	fail string
+}
+
+// fakeEdge 通过真实websocket连接接入EdgeSet的边缘节点, 按handle的返回值响应请求
+type fakeEdge struct {
+	gws.BuiltinEventHandler
+	*Edge
+	handle func(request *transport.WebsocketProxyRequest, body []byte) fakeReply
+
+	streams sync.Map
+	// requests 收到的请求数, resets 响应前被服务端重置的请求数
+	requests atomic.Int32
+	resets   atomic.Int32
+}
+
+func (f *fakeEdge) OnMessage(socket *gws.Conn, message *gws.Message) {
+	defer message.Close()
+	frame, e := transport.ReadFrame(message)
+	if e != nil {
+		return
+	}
+	if frame.Type != transport.FrameTypeRequest {
+		if value, ok := f.streams.Load(frame.StreamId); ok {
+			value.(*transport.Stream).HandleFrame(frame)
+		}
+		return
+	}
+	var request transport.WebsocketProxyRequest
+	if msgpack.Unmarshal(frame.Data, &request) != nil {
+		return
+	}
+	f.requests.Add(1)
+	stream := transport.NewStream(frame.StreamId, socket, func() {
+		f.streams.Delete(frame.StreamId)
+	})
+	f.streams.Store(frame.StreamId, stream)
+	go f.respond(socket, &request, stream)
+}
+
+func (f *fakeEdge) respond(socket *gws.Conn, request *transport.WebsocketProxyRequest, stream *transport.Stream) {
+	defer stream.Close()
+	body, _ := io.ReadAll(stream)
+	reply := f.handle(request, body)
+	select {
+	case <-stream.Aborted():
+		f.resets.Add(1)
+		return
+	case <-time.After(reply.delay):
+	}
+	response := transport.WebsocketProxyResponse{Success: reply.fail == "", ErrorMessage: reply.fail,
+		StatusCode: reply.status, ContentLength: int64(len(reply.body))}
+	data, _ := msgpack.Marshal(&response)
+	if transport.WriteFrame(socket, &transport.Frame{Type: transport.FrameTypeResponse, StreamId: stream.Id, Data: data}) != nil {
+		return
+	}
+	if response.Success {
+		_ = transport.CopyAndClose(stream, bytes.NewReader(reply.body))
+	}
+}
+
+// edgeSetHandler 服务端把节点的消息交给EdgeSet
+type edgeSetHandler struct {
+	gws.BuiltinEventHandler
+	set *EdgeSet
+}
+
+func (h edgeSetHandler) OnMessage(socket *gws.Conn, message *gws.Message) {
+	_ = h.set.OnMessage(socket, message)
+}
+
+func (h edgeSetHandler) OnClose(socket *gws.Conn, _ error) {
+	_ = h.set.RemoveByConnection(socket)
+}
+
+// addFakeEdge 连接一个测试节点并等待它加入EdgeSet
+func addFakeEdge(t *testing.T, s *EdgeSet, info EdgeInfo,
+	handle func(request *transport.WebsocketProxyRequest, body []byte) fakeReply) *fakeEdge {
+	t.Helper()
+	added := make(chan string, 1)
+	upgrader := gws.NewUpgrader(edgeSetHandler{set: s}, nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, e := upgrader.Upgrade(w, r)
+		if e != nil {
+			return
+		}
+		added <- s.Add(conn, info)
+		conn.ReadLoop()
+	}))
+	t.Cleanup(server.Close)
+
+	fake := &fakeEdge{handle: handle}
+	conn, _, e := gws.NewClient(fake, &gws.ClientOption{Addr: "ws://" + strings.TrimPrefix(server.URL, "http://")})
+	if e != nil {
+		t.Fatal(e)
+	}
+	go conn.ReadLoop()
+	t.Cleanup(func() {
+		_ = conn.NetConn().Close()
+	})
+	edgeId := <-added
+	s.RLock()
+	for _, edge := range s.edges {
+		if edge.EdgeId == edgeId {
+			fake.Edge = edge
+		}
+	}
+	s.RUnlock()
+	return fake
+}
+
+// replyStatus 立即返回状态码
+func replyStatus(status int) func(*transport.WebsocketProxyRequest, []byte) fakeReply {
+	return func(*transport.WebsocketProxyRequest, []byte) fakeReply {
+		return fakeReply{status: status}
+	}
+}
+
+// waitIdle 等待节点上的请求全部释放, 名额被重复释放时InFlight会小于0
+func waitIdle(t *testing.T, edges ...*fakeEdge) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for _, edge := range edges {
+		for edge.InFlight.Load() > 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if got := edge.InFlight.Load(); got != 0 {
+			t.Fatalf("edge %s in flight = %d", edge.EdgeId, got)
+		}
+	}
+}
+
+// eventually 等待条件成立, 超过2秒失败
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// addEdges 不建立连接直接加入节点, 与Add一样为排队的请求分配节点
+func addEdges(s *EdgeSet, edges ...*Edge) {
+	s.Lock()
+	defer s.Unlock()
+	s.edges = append(s.edges, edges...)
+	s.serveWaitersLocked()
+}
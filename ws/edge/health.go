@@ -0,0 +1,246 @@
+package edge
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// CircuitState 节点熔断器状态
+type CircuitState int
+
+const (
+	// CircuitClosed 正常分发
+	CircuitClosed CircuitState = iota
+	// CircuitOpen 熔断中, 不再分发请求
+	CircuitOpen
+	// CircuitHalfOpen 熔断时间已过, 放行少量探测请求
+	CircuitHalfOpen
+)
+
+func (c CircuitState) String() string {
+	switch c {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// HealthPolicy 节点健康统计和熔断的阈值
+type HealthPolicy struct {
+	// Window 统计最近多少次请求
+	Window int
+	// MinRequests 窗口内请求数不少于该值时才判断是否熔断
+	MinRequests int
+	// MaxFailureRate 失败率超过该值时熔断, 为0时不按失败率熔断.
+	// 只统计节点自身的故障(超时、节点报告的本机网络或DNS故障), 目标返回的错误不计入
+	MaxFailureRate float64
+	// MaxTimeoutRate 超时率超过该值时熔断, 为0时不按超时率熔断
+	MaxTimeoutRate float64
+	// MaxLatency 平均响应时间超过该值时熔断, 为0时不按响应时间熔断
+	MaxLatency time.Duration
+	// OpenDuration 熔断多久后开始探测
+	OpenDuration time.Duration
+	// ProbeRequests 半开状态放行的探测请求数, 全部成功后恢复
+	ProbeRequests int
+}
+
+// DefaultHealthPolicy 默认的熔断阈值
+func DefaultHealthPolicy() *HealthPolicy {
+	return &HealthPolicy{
+		Window:         50,
+		MinRequests:    10,
+		MaxFailureRate: 0.5,
+		MaxTimeoutRate: 0.3,
+		OpenDuration:   30 * time.Second,
+		ProbeRequests:  3,
+	}
+}
+
+// HealthStats 节点最近请求的统计
+type HealthStats struct {
+	State       CircuitState
+	Requests    int
+	SuccessRate float64
+	TimeoutRate float64
+	AvgLatency  time.Duration
+}
+
+type outcome struct {
+	success bool
+	timeout bool
+	latency time.Duration
+}
+
+// health 节点最近请求结果的滑动窗口和熔断状态
+type health struct {
+	mu       sync.Mutex
+	outcomes []outcome
+	next     int
+	full     bool
+
+	state       CircuitState
+	openedAt    time.Time
+	probesSent  int
+	probesValid int
+}
+
+func (h *health) statsLocked() HealthStats {
+	count := h.next
+	if h.full {
+		count = len(h.outcomes)
+	}
+	stats := HealthStats{State: h.state, Requests: count}
+	if count == 0 {
+		return stats
+	}
+	var success, timeout int
+	var latency time.Duration
+	for _, o := range h.outcomes[:count] {
+		if o.success {
+			success++
+		}
+		if o.timeout {
+			timeout++
+		}
+		latency += o.latency
+	}
+	stats.SuccessRate = float64(success) / float64(count)
+	stats.TimeoutRate = float64(timeout) / float64(count)
+	stats.AvgLatency = latency / time.Duration(count)
+	return stats
+}
+
+func (h *health) stats() HealthStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.statsLocked()
+}
+
+// available 判断节点当前是否可以接收请求, 不改变状态
+func (h *health) available(policy *HealthPolicy, now time.Time) bool {
+	if policy == nil {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch h.state {
+	case CircuitOpen:
+		return now.Sub(h.openedAt) >= policy.OpenDuration
+	case CircuitHalfOpen:
+		// 探测请求迟迟没有结果(如被隧道占用)时允许再发一批
+		return h.probesSent < policy.ProbeRequests || now.Sub(h.openedAt) >= policy.OpenDuration
+	default:
+		return true
+	}
+}
+
+// acquire 节点被选中时调用, 熔断时间已过则进入半开状态并计入探测请求
+func (h *health) acquire(policy *HealthPolicy, now time.Time) {
+	if policy == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch h.state {
+	case CircuitOpen:
+		h.state = CircuitHalfOpen
+		h.openedAt = now
+		h.probesSent = 1
+		h.probesValid = 0
+	case CircuitHalfOpen:
+		if h.probesSent >= policy.ProbeRequests {
+			h.openedAt = now
+			h.probesSent = 0
+		}
+		h.probesSent++
+	}
+}
+
+// record 记录一次请求结果, 返回熔断状态是否发生变化
+func (h *health) record(o outcome, policy *HealthPolicy, now time.Time) (CircuitState, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	window := 50
+	if policy != nil && policy.Window > 0 {
+		window = policy.Window
+	}
+	if len(h.outcomes) != window {
+		h.outcomes = make([]outcome, window)
+		h.next, h.full = 0, false
+	}
+	h.outcomes[h.next] = o
+	h.next++
+	if h.next == window {
+		h.next, h.full = 0, true
+	}
+	if policy == nil {
+		return h.state, false
+	}
+
+	switch h.state {
+	case CircuitHalfOpen:
+		if !o.success {
+			h.open(now)
+			return h.state, true
+		}
+		h.probesValid++
+		if h.probesValid >= policy.ProbeRequests {
+			// 恢复后重新统计, 避免熔断前的结果立即再次触发熔断
+			h.state = CircuitClosed
+			h.next, h.full = 0, false
+			return h.state, true
+		}
+	case CircuitClosed:
+		if h.tripped(policy) {
+			h.open(now)
+			return h.state, true
+		}
+	}
+	return h.state, false
+}
+
+func (h *health) open(now time.Time) {
+	h.state = CircuitOpen
+	h.openedAt = now
+	h.probesSent = 0
+	h.probesValid = 0
+}
+
+func (h *health) tripped(policy *HealthPolicy) bool {
+	stats := h.statsLocked()
+	if stats.Requests < policy.MinRequests {
+		return false
+	}
+	if policy.MaxFailureRate > 0 && 1-stats.SuccessRate > policy.MaxFailureRate {
+		return true
+	}
+	if policy.MaxTimeoutRate > 0 && stats.TimeoutRate > policy.MaxTimeoutRate {
+		return true
+	}
+	return policy.MaxLatency > 0 && stats.AvgLatency > policy.MaxLatency
+}
+
+// Health 节点最近请求的统计和熔断状态
+func (e *Edge) Health() HealthStats {
+	return e.health.stats()
+}
+
+// SetHealthPolicy 设置熔断阈值, 为nil时只统计不熔断
+func (s *EdgeSet) SetHealthPolicy(policy *HealthPolicy) {
+	s.healthPolicy.Store(policy)
+}
+
+// recordOutcome 记录节点处理请求的结果并更新熔断状态
+func (s *EdgeSet) recordOutcome(edge *Edge, o outcome) {
+	if o.success {
+		s.latencies.add(o.latency)
+	}
+	state, changed := edge.health.record(o, s.healthPolicy.Load(), time.Now())
+	if changed {
+		log.Println("节点熔断状态变化:", edge.EdgeId, state)
+	}
+}
@@ -0,0 +1,173 @@
+package edge
+
+import (
+	"asyncProxy/ws/transport"
+	"testing"
+	"time"
+)
+
+func testHealthPolicy() *HealthPolicy {
+	return &HealthPolicy{
+		Window:         10,
+		MinRequests:    4,
+		MaxFailureRate: 0.5,
+		MaxTimeoutRate: 0.3,
+		OpenDuration:   time.Minute,
+		ProbeRequests:  2,
+	}
+}
+
+var (
+	outcomeOk      = outcome{success: true, latency: 10 * time.Millisecond}
+	outcomeFailed  = outcome{latency: 10 * time.Millisecond}
+	outcomeTimeout = outcome{timeout: true, latency: time.Second}
+	outcomeSlow    = outcome{success: true, latency: time.Second}
+)
+
+func TestHealthTrip(t *testing.T) {
+	latencyPolicy := testHealthPolicy()
+	latencyPolicy.MaxLatency = 500 * time.Millisecond
+	tests := []struct {
+		name     string
+		policy   *HealthPolicy
+		outcomes []outcome
+		want     CircuitState
+	}{
+		{"healthy", testHealthPolicy(), []outcome{outcomeOk, outcomeOk, outcomeFailed, outcomeOk, outcomeOk}, CircuitClosed},
+		{"below min requests", testHealthPolicy(), []outcome{outcomeFailed, outcomeFailed, outcomeFailed}, CircuitClosed},
+		{"failure rate", testHealthPolicy(), []outcome{outcomeOk, outcomeFailed, outcomeFailed, outcomeFailed}, CircuitOpen},
+		{"failure rate at threshold", testHealthPolicy(), []outcome{outcomeOk, outcomeOk, outcomeFailed, outcomeFailed}, CircuitClosed},
+		{"timeout rate", testHealthPolicy(), []outcome{outcomeOk, outcomeOk, outcomeTimeout, outcomeTimeout}, CircuitOpen},
+		{"latency", latencyPolicy, []outcome{outcomeSlow, outcomeSlow, outcomeSlow, outcomeOk}, CircuitOpen},
+		{"latency disabled", testHealthPolicy(), []outcome{outcomeSlow, outcomeSlow, outcomeSlow, outcomeSlow}, CircuitClosed},
+		{"nil policy never trips", nil, []outcome{outcomeFailed, outcomeFailed, outcomeFailed, outcomeFailed, outcomeFailed}, CircuitClosed},
+		// 窗口只统计最近10次
+		{"old failures leave window", testHealthPolicy(), []outcome{outcomeFailed, outcomeFailed, outcomeOk, outcomeOk, outcomeOk, outcomeOk, outcomeOk, outcomeOk, outcomeOk, outcomeOk, outcomeOk, outcomeOk}, CircuitClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &health{}
+			now := time.Now()
+			for _, o := range tt.outcomes {
+				h.record(o, tt.policy, now)
+			}
+			if got := h.stats().State; got != tt.want {
+				t.Fatalf("state = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthRecovery(t *testing.T) {
+	policy := testHealthPolicy()
+	openedAt := time.Now()
+	afterOpen := openedAt.Add(policy.OpenDuration)
+	tests := []struct {
+		name string
+		// run 从刚熔断的状态开始操作
+		run  func(h *health) CircuitState
+		want CircuitState
+	}{
+		{"unavailable while open", func(h *health) CircuitState {
+			if h.available(policy, openedAt.Add(time.Second)) {
+				t.Fatal("available while open")
+			}
+			return h.state
+		}, CircuitOpen},
+		{"half open after open duration", func(h *health) CircuitState {
+			if !h.available(policy, afterOpen) {
+				t.Fatal("unavailable after open duration")
+			}
+			h.acquire(policy, afterOpen)
+			return h.state
+		}, CircuitHalfOpen},
+		{"probes limited", func(h *health) CircuitState {
+			h.acquire(policy, afterOpen)
+			h.acquire(policy, afterOpen)
+			if h.available(policy, afterOpen.Add(time.Second)) {
+				t.Fatal("available after all probes sent")
+			}
+			return h.state
+		}, CircuitHalfOpen},
+		{"probe failure reopens", func(h *health) CircuitState {
+			h.acquire(policy, afterOpen)
+			h.record(outcomeOk, policy, afterOpen)
+			h.record(outcomeFailed, policy, afterOpen)
+			if h.available(policy, afterOpen.Add(time.Second)) {
+				t.Fatal("available right after reopening")
+			}
+			return h.state
+		}, CircuitOpen},
+		{"probes succeed closes", func(h *health) CircuitState {
+			h.acquire(policy, afterOpen)
+			h.acquire(policy, afterOpen)
+			h.record(outcomeOk, policy, afterOpen)
+			_, changed := h.record(outcomeOk, policy, afterOpen)
+			if !changed {
+				t.Fatal("state change not reported")
+			}
+			// 恢复后重新统计, 之前的失败不会立即再次熔断
+			if stats := h.stats(); stats.Requests != 0 {
+				t.Fatalf("window not reset: %d requests", stats.Requests)
+			}
+			h.record(outcomeFailed, policy, afterOpen)
+			return h.state
+		}, CircuitClosed},
+		{"stuck probes retried after open duration", func(h *health) CircuitState {
+			h.acquire(policy, afterOpen)
+			h.acquire(policy, afterOpen)
+			if !h.available(policy, afterOpen.Add(policy.OpenDuration)) {
+				t.Fatal("unavailable after probes timed out")
+			}
+			return h.state
+		}, CircuitHalfOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &health{}
+			for i := 0; i < policy.MinRequests; i++ {
+				h.record(outcomeFailed, policy, openedAt)
+			}
+			if h.state != CircuitOpen {
+				t.Fatalf("setup: state = %v", h.state)
+			}
+			if got := tt.run(h); got != tt.want {
+				t.Fatalf("state = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseOutcome(t *testing.T) {
+	latency := 10 * time.Millisecond
+	tests := []struct {
+		name     string
+		response transport.WebsocketProxyResponse
+		want     outcome
+		recorded bool
+	}{
+		{"success", transport.WebsocketProxyResponse{Success: true, StatusCode: 200}, outcome{success: true, latency: latency}, true},
+		{"target status error", transport.WebsocketProxyResponse{Success: true, StatusCode: 503}, outcome{success: true, latency: latency}, true},
+		{"target failure", transport.WebsocketProxyResponse{ErrorMessage: "no such host"}, outcome{}, false},
+		{"edge failure", transport.WebsocketProxyResponse{ErrorMessage: "network is unreachable", EdgeFailure: true}, outcome{latency: latency}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, recorded := responseOutcome(&tt.response, latency)
+			if got != tt.want || recorded != tt.recorded {
+				t.Fatalf("got %+v, %v, want %+v, %v", got, recorded, tt.want, tt.recorded)
+			}
+		})
+	}
+
+	// 节点持续报告自身故障时熔断
+	policy := testHealthPolicy()
+	h := &health{}
+	for i := 0; i < policy.MinRequests; i++ {
+		o, _ := responseOutcome(&transport.WebsocketProxyResponse{EdgeFailure: true}, latency)
+		h.record(o, policy, time.Now())
+	}
+	if h.state != CircuitOpen {
+		t.Fatalf("state = %v after edge failures", h.state)
+	}
+}
@@ -0,0 +1,159 @@
+package edge
+
+import (
+	"asyncProxy/ws/transport"
+	"context"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// hedgeMinSamples 按分位数计算延迟时至少需要的样本数
+const hedgeMinSamples = 20
+
+// HedgePolicy 第一份请求迟迟没有响应时, 向另一个节点发送第二份请求, 使用先返回的响应
+type HedgePolicy struct {
+	// Delay 第一份请求超过该时间没有响应时发送第二份
+	Delay time.Duration
+	// Percentile 大于0时使用最近请求响应时间的该分位数(如0.95)作为延迟, 样本不足时使用Delay
+	Percentile float64
+	// Methods 可以对冲的请求方法, 为空时只对冲幂等方法
+	Methods []string
+}
+
+func (p *HedgePolicy) enabled(method string) bool {
+	return p != nil && methodAllowed(p.Methods, method)
+}
+
+// latencies 最近成功请求的响应时间
+type latencies struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (l *latencies) add(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) < 256 {
+		l.samples = append(l.samples, latency)
+		return
+	}
+	l.samples[l.next] = latency
+	l.next = (l.next + 1) % len(l.samples)
+}
+
+// percentile 返回分位数, 样本不足时返回false
+func (l *latencies) percentile(p float64) (time.Duration, bool) {
+	l.mu.Lock()
+	sorted := slices.Clone(l.samples)
+	l.mu.Unlock()
+	if len(sorted) < hedgeMinSamples {
+		return 0, false
+	}
+	slices.Sort(sorted)
+	index := int(float64(len(sorted)-1) * min(p, 1))
+	return sorted[index], true
+}
+
+// SetHedgePolicy 设置请求对冲策略, 为nil时不对冲
+func (s *EdgeSet) SetHedgePolicy(policy *HedgePolicy) {
+	s.hedgePolicy.Store(policy)
+}
+
+func (s *EdgeSet) hedgeDelay(policy *HedgePolicy) time.Duration {
+	if policy.Percentile > 0 {
+		if delay, ok := s.latencies.percentile(policy.Percentile); ok {
+			return delay
+		}
+	}
+	return policy.Delay
+}
+
+// dispatchHedged 先发送一份请求, 超过对冲延迟仍没有响应时向另一个空闲节点再发送一份.
+// 使用先成功的响应, 另一份请求被取消
+func (s *EdgeSet) dispatchHedged(ctx context.Context, request *Request, body replayableBody,
+	policy *HedgePolicy) (*transport.WebsocketProxyResponse, error) {
+	results := make(chan *transport.WebsocketProxyResponse, 2)
+	callback := func(response *transport.WebsocketProxyResponse) {
+		results <- response
+	}
+	attempt := *request
+	attempt.Body = newBodyReader(body)
+	first, e := s.send(ctx, &attempt, callback)
+	if e != nil {
+		return nil, e
+	}
+	pending := map[string]*requestCallback{first.RequestId: first}
+
+	timer := time.NewTimer(s.hedgeDelay(policy))
+	defer timer.Stop()
+	hedge := timer.C
+	for {
+		select {
+		case <-ctx.Done():
+			s.cancelPending(pending)
+			return nil, ctx.Err()
+		case <-hedge:
+			hedge = nil
+			if _, ok := pending[first.RequestId]; !ok {
+				continue
+			}
+			hedgeOptions := DispatchOptions{noWait: true}
+			if request.Options != nil {
+				hedgeOptions = *request.Options
+				hedgeOptions.noWait = true
+			}
+			hedgeOptions.ExcludeEdges = append(slices.Clone(hedgeOptions.ExcludeEdges), first.EdgeId)
+			attempt := *request
+			attempt.Body = newBodyReader(body)
+			attempt.Options = &hedgeOptions
+			// 没有其他空闲节点时只等待第一份请求
+			if second, e := s.send(ctx, &attempt, callback); e == nil {
+				pending[second.RequestId] = second
+			}
+		case response := <-results:
+			delete(pending, response.RequestId)
+			if !response.Success && len(pending) > 0 {
+				continue
+			}
+			s.cancelPending(pending)
+			return response, nil
+		}
+	}
+}
+
+// cancelPending 取消未完成的请求, 被取消的请求可能已经收到了响应, 需要关闭响应体
+func (s *EdgeSet) cancelPending(pending map[string]*requestCallback) {
+	for requestId, c := range pending {
+		s.CancelRequest(requestId)
+		<-c.CompleteChan
+		if c.response.BodyReader != nil {
+			_ = c.response.BodyReader.Close()
+		}
+	}
+}
+
+// replayableBody 可以重复读取的请求体, 为nil表示没有请求体
+type replayableBody interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// replayable 判断请求体能否重复发送, 返回值为nil且ok为true表示没有请求体
+func replayable(body io.Reader) (replayableBody, bool) {
+	if body == nil {
+		return nil, true
+	}
+	r, ok := body.(replayableBody)
+	return r, ok
+}
+
+// newBodyReader 每次发送使用独立的reader, 上一份请求可能仍在读取请求体
+func newBodyReader(body replayableBody) io.Reader {
+	if body == nil {
+		return nil
+	}
+	return io.NewSectionReader(body, 0, body.Size())
+}
@@ -0,0 +1,151 @@
+package edge
+
+import (
+	"asyncProxy/ws/transport"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// syncMapLen 返回sync.Map中的元素个数
+func syncMapLen(m *sync.Map) int {
+	n := 0
+	m.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func TestDispatchHedged(t *testing.T) {
+	tests := []struct {
+		name string
+		// replies 按节点收到请求的先后顺序响应
+		replies  []fakeReply
+		cancel   bool
+		wantBody string
+		wantFail string
+		// wantRequests 节点收到的请求数, wantResets 被取消的请求数, 按收到请求的先后顺序
+		wantRequests int
+		wantResets   []int32
+	}{
+		{"first responds before delay", []fakeReply{{status: http.StatusOK, body: []byte("first")}},
+			false, "first", "", 1, []int32{0}},
+		{"second wins", []fakeReply{{status: http.StatusOK, body: []byte("first"), delay: time.Second},
+			{status: http.StatusOK, body: []byte("second")}}, false, "second", "", 2, []int32{1, 0}},
+		{"first fails after hedge", []fakeReply{{fail: "dial error", delay: 100 * time.Millisecond},
+			{status: http.StatusOK, body: []byte("second"), delay: 200 * time.Millisecond}}, false, "second", "", 2, []int32{0, 0}},
+		{"both fail", []fakeReply{{fail: "first error", delay: 100 * time.Millisecond},
+			{fail: "second error", delay: 150 * time.Millisecond}}, false, "", "second error", 2, []int32{0, 0}},
+		{"cancel", []fakeReply{{status: http.StatusOK, delay: time.Second},
+			{status: http.StatusOK, delay: time.Second}}, true, "", "", 2, []int32{1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewEdgeSet()
+			s.SetHedgePolicy(&HedgePolicy{Delay: 50 * time.Millisecond})
+			var mu sync.Mutex
+			var order []string
+			handle := func(request *transport.WebsocketProxyRequest, _ []byte) fakeReply {
+				mu.Lock()
+				defer mu.Unlock()
+				order = append(order, request.EdgeId)
+				return tt.replies[len(order)-1]
+			}
+			edges := map[string]*fakeEdge{}
+			for i := 0; i < 2; i++ {
+				fake := addFakeEdge(t, s, EdgeInfo{}, handle)
+				edges[fake.EdgeId] = fake
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				time.AfterFunc(150*time.Millisecond, cancel)
+			}
+			response, e := s.DispatchRequestContext(ctx, &Request{Method: http.MethodGet, Url: "http://example.com", Timeout: 2 * time.Second})
+			switch {
+			case tt.cancel:
+				if e != context.Canceled {
+					t.Fatalf("error = %v", e)
+				}
+			case e != nil:
+				t.Fatal(e)
+			case tt.wantFail != "":
+				if response.Success || response.ErrorMessage != tt.wantFail {
+					t.Fatalf("response success=%v error=%q", response.Success, response.ErrorMessage)
+				}
+			default:
+				body, _ := io.ReadAll(response.BodyReader)
+				_ = response.BodyReader.Close()
+				if !response.Success || !bytes.Equal(body, []byte(tt.wantBody)) {
+					t.Fatalf("response success=%v body=%q", response.Success, body)
+				}
+			}
+
+			all := make([]*fakeEdge, 0, len(edges))
+			for _, fake := range edges {
+				all = append(all, fake)
+			}
+			waitIdle(t, all...)
+			// 等待被取消的请求到达节点, 再确认没有多余的重置
+			eventually(t, "edges not reset", func() bool {
+				total := int32(0)
+				for _, fake := range edges {
+					total += fake.resets.Load()
+				}
+				want := int32(0)
+				for _, n := range tt.wantResets {
+					want += n
+				}
+				return total == want
+			})
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			if len(order) != tt.wantRequests {
+				t.Fatalf("edges received %d requests", len(order))
+			}
+			for i, edgeId := range order {
+				if got := edges[edgeId].resets.Load(); got != tt.wantResets[i] {
+					t.Fatalf("request #%d reset %d times, want %d", i, got, tt.wantResets[i])
+				}
+			}
+			if n := syncMapLen(&s.callbacks) + syncMapLen(&s.streams); n != 0 {
+				t.Fatalf("%d callbacks or streams left", n)
+			}
+		})
+	}
+}
+
+func TestRequestCallbackFinishOnce(t *testing.T) {
+	var calls atomic.Int32
+	c := &requestCallback{
+		RequestId:    "request",
+		CompleteChan: make(chan struct{}),
+		Complete: func(*transport.WebsocketProxyResponse) {
+			calls.Add(1)
+		},
+	}
+	var fired atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c.finish(&transport.WebsocketProxyResponse{RequestId: c.RequestId}) {
+				fired.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	<-c.CompleteChan
+	if calls.Load() != 1 || fired.Load() != 1 {
+		t.Fatalf("callback called %d times, finish fired %d times", calls.Load(), fired.Load())
+	}
+}
@@ -0,0 +1,109 @@
+package edge
+
+import (
+	"asyncProxy/constant/errcode"
+	"asyncProxy/errors"
+	"asyncProxy/ws/transport"
+	"github.com/lxzan/gws"
+	"github.com/vmihailenco/msgpack/v5"
+	"time"
+)
+
+// OnMessage 处理边缘节点发来的消息帧
+func (s *EdgeSet) OnMessage(conn *gws.Conn, message *gws.Message) error {
+	defer message.Close()
+	if message.Opcode != gws.OpcodeBinary {
+		return errors.NewBusinessError(errcode.ErrorInvalidResponseMessageType, "错误的消息类型")
+	}
+	edgeId, e := edgeIdOf(conn)
+	if e != nil {
+		return e
+	}
+	frame, e := transport.ReadFrame(message)
+	if e != nil {
+		return errors.NewBusinessError(errcode.ErrorSerializeOrDeserializeFailed, "边缘节点消息解码失败").WithInnerError(e)
+	}
+
+	switch frame.Type {
+	case transport.FrameTypeResponse:
+		return s.onResponse(conn, edgeId, frame)
+	default:
+		if association := s.loadAssociation(conn, frame.StreamId); association != nil {
+			association.HandleFrame(frame)
+			return nil
+		}
+		stream := s.loadStream(conn, frame.StreamId)
+		if stream == nil {
+			// 流已结束, 丢弃迟到的帧
+			return nil
+		}
+		if s.onTunnelFrame(frame) && frame.Type == transport.FrameTypeTunnelOpened {
+			return nil
+		}
+		if !stream.HandleFrame(frame) {
+			return errors.NewBusinessError(errcode.ErrorInvalidResponseMessageType, "错误的消息帧类型")
+		}
+	}
+	return nil
+}
+
+func (s *EdgeSet) onResponse(conn *gws.Conn, edgeId string, frame *transport.Frame) error {
+	var wsResponse transport.WebsocketProxyResponse
+	e := msgpack.Unmarshal(frame.Data, &wsResponse)
+	if e != nil {
+		return errors.NewBusinessError(errcode.ErrorSerializeOrDeserializeFailed, "边缘节点响应解码失败").WithInnerError(e)
+	}
+
+	wsResponse.EdgeId = edgeId
+	wsResponse.RequestId = frame.StreamId
+	stream := s.loadStream(conn, frame.StreamId)
+
+	callback, _ := s.callbacks.LoadAndDelete(frame.StreamId)
+	c, ok := callback.(*requestCallback)
+	if !ok || stream == nil {
+		// 请求已超时或已被取消
+		if stream != nil {
+			_ = stream.Close()
+		}
+		return nil
+	}
+
+	if wsResponse.Success {
+		wsResponse.BodyReader = stream
+	} else {
+		_ = stream.Close()
+	}
+	if !c.finish(&wsResponse) {
+		_ = stream.Close()
+		return nil
+	}
+	if o, ok := responseOutcome(&wsResponse, time.Since(c.startAt)); ok {
+		s.recordOutcome(c.edge, o)
+	}
+	return nil
+}
+
+// responseOutcome 返回边缘节点响应对应的健康统计结果.
+// 目标的DNS、连接、TLS等错误不代表节点异常, 不计入统计; 节点报告的自身故障计为失败
+func responseOutcome(response *transport.WebsocketProxyResponse, latency time.Duration) (outcome, bool) {
+	switch {
+	case response.Success:
+		return outcome{success: true, latency: latency}, true
+	case response.EdgeFailure:
+		return outcome{latency: latency}, true
+	default:
+		return outcome{}, false
+	}
+}
+
+func (s *EdgeSet) loadStream(conn *gws.Conn, streamId string) *transport.Stream {
+	value, ok := s.streams.Load(streamId)
+	if !ok {
+		return nil
+	}
+	stream, ok := value.(*transport.Stream)
+	if !ok || stream.Conn() != conn {
+		return nil
+	}
+	return stream
+}
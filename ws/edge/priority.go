@@ -0,0 +1,66 @@
+package edge
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Priority 请求的优先级, 节点都繁忙时优先级高的排队请求先分配节点
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// DefaultPriorityAging 排队每超过该时间优先级提升一级, 避免低优先级的请求一直等不到节点
+const DefaultPriorityAging = 5 * time.Second
+
+// ParsePriority 解析 low、normal、high, 为空时为normal
+func ParsePriority(value string) (Priority, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "low":
+		return PriorityLow, nil
+	case "", "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	default:
+		return PriorityNormal, fmt.Errorf("unknown priority: %s", value)
+	}
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityHigh:
+		return "high"
+	default:
+		return "normal"
+	}
+}
+
+func (o *DispatchOptions) priority() Priority {
+	if o == nil {
+		return PriorityNormal
+	}
+	return o.Priority
+}
+
+// SetPriorityAging 设置排队请求优先级提升的间隔, 为0时使用默认值
+func (s *EdgeSet) SetPriorityAging(aging time.Duration) {
+	if aging <= 0 {
+		aging = DefaultPriorityAging
+	}
+	s.RWMutex.Lock()
+	defer s.RWMutex.Unlock()
+	s.priorityAging = aging
+}
+
+// effectivePriority 排队请求当前的优先级, 等待越久越高
+func (s *EdgeSet) effectivePriority(w *waiter, now time.Time) Priority {
+	return w.options.priority() + Priority(now.Sub(w.enqueuedAt)/s.priorityAging)
+}
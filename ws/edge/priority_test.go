@@ -0,0 +1,144 @@
+package edge
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParsePriority(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Priority
+		wantErr bool
+	}{
+		{"", PriorityNormal, false},
+		{"normal", PriorityNormal, false},
+		{" HIGH ", PriorityHigh, false},
+		{"low", PriorityLow, false},
+		{"urgent", PriorityNormal, true},
+	}
+	for _, tt := range tests {
+		got, e := ParsePriority(tt.value)
+		if got != tt.want || (e != nil) != tt.wantErr {
+			t.Fatalf("ParsePriority(%q) = %v, %v", tt.value, got, e)
+		}
+	}
+}
+
+func TestEffectivePriority(t *testing.T) {
+	s := NewEdgeSet()
+	s.SetPriorityAging(time.Second)
+	now := time.Now()
+	tests := []struct {
+		name     string
+		priority Priority
+		waited   time.Duration
+		want     Priority
+	}{
+		{"new", PriorityNormal, 0, PriorityNormal},
+		{"below one aging", PriorityLow, 999 * time.Millisecond, PriorityLow},
+		{"one aging", PriorityLow, time.Second, PriorityNormal},
+		{"two agings", PriorityLow, 2500 * time.Millisecond, PriorityHigh},
+		{"keeps rising", PriorityHigh, 3 * time.Second, PriorityHigh + 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &waiter{options: &DispatchOptions{Priority: tt.priority}, enqueuedAt: now.Add(-tt.waited)}
+			if got := s.effectivePriority(w, now); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// serveInOrder 逐个释放唯一的并发名额, 返回排队请求被分配节点的顺序
+func serveInOrder(t *testing.T, s *EdgeSet, busy *Edge, results <-chan pickResult, count int) []string {
+	t.Helper()
+	var order []string
+	holder := busy
+	for i := 0; i < count; i++ {
+		s.release(holder)
+		select {
+		case got := <-results:
+			if got.err != nil {
+				t.Fatalf("%s: %v", got.name, got.err)
+			}
+			order = append(order, got.name)
+			holder = got.edge
+		case <-time.After(2 * time.Second):
+			t.Fatalf("waiter #%d not served", i)
+		}
+	}
+	s.release(holder)
+	return order
+}
+
+func TestQueuePriorityOrder(t *testing.T) {
+	s := NewEdgeSet()
+	s.SetPriorityAging(time.Minute)
+	edge := testEdge("a", 0, 0, 0, time.Now())
+	edge.Info.MaxInFlight = 1
+	addEdges(s, edge)
+	busy, _ := s.pickEdge(context.Background(), nil)
+
+	results := make(chan pickResult, 5)
+	for _, w := range []struct {
+		name     string
+		priority Priority
+	}{
+		{"normal 1", PriorityNormal},
+		{"high 1", PriorityHigh},
+		{"low", PriorityLow},
+		{"high 2", PriorityHigh},
+		{"normal 2", PriorityNormal},
+	} {
+		enqueue(t, s, context.Background(), w.name, &DispatchOptions{Priority: w.priority}, results)
+	}
+	// 优先级高的先分配, 同一优先级先进先出
+	want := []string{"high 1", "high 2", "normal 1", "normal 2", "low"}
+	got := serveInOrder(t, s, busy, results, len(want))
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("served %v, want %v", got, want)
+		}
+	}
+}
+
+func TestQueuePriorityAging(t *testing.T) {
+	const aging = 50 * time.Millisecond
+	s := NewEdgeSet()
+	s.SetPriorityAging(aging)
+	edge := testEdge("a", 0, 0, 0, time.Now())
+	edge.Info.MaxInFlight = 1
+	addEdges(s, edge)
+	holder, _ := s.pickEdge(context.Background(), nil)
+
+	results := make(chan pickResult, 2)
+	enqueue(t, s, context.Background(), "low", &DispatchOptions{Priority: PriorityLow}, results)
+	high := &DispatchOptions{Priority: PriorityHigh}
+	// 持续有新的高优先级请求排队, 低优先级的请求等待两个aging后与之同级, 按到达顺序先分配
+	for round := 0; ; round++ {
+		if round > 40 {
+			t.Fatal("low priority waiter starved")
+		}
+		enqueue(t, s, context.Background(), "high", high, results)
+		time.Sleep(aging / 4)
+		s.release(holder)
+		got := <-results
+		holder = got.edge
+		if got.name == "low" {
+			if waited := time.Duration(round) * aging / 4; round == 0 || waited < aging {
+				t.Fatalf("low priority served too early, round %d", round)
+			}
+			break
+		}
+	}
+	// 剩下的高优先级请求
+	s.release(holder)
+	got := <-results
+	s.release(got.edge)
+	if s.QueueLen() != 0 || edge.InFlight.Load() != 0 {
+		t.Fatalf("queue %d, in flight %d", s.QueueLen(), edge.InFlight.Load())
+	}
+}
@@ -0,0 +1,239 @@
+package edge
+
+import (
+	"asyncProxy/constant/errcode"
+	"asyncProxy/errors"
+	"asyncProxy/ws/transport"
+	"cmp"
+	"container/list"
+	"context"
+	goerrors "errors"
+	"slices"
+	"time"
+)
+
+const (
+	// DefaultQueueSize 等待空闲节点的请求数上限
+	DefaultQueueSize = 1024
+	// DefaultQueueTimeout 请求等待空闲节点的最长时间
+	DefaultQueueTimeout = 30 * time.Second
+)
+
+// waiter 所有可用节点都达到并发上限时排队的请求
+type waiter struct {
+	options    *DispatchOptions
+	enqueuedAt time.Time
+	// edge 被分配的节点, 已占用一个并发名额
+	edge     chan *Edge
+	assigned bool
+	// offline 等待节点上线而不是等待空闲节点
+	offline bool
+}
+
+// SetQueue 设置排队的请求数上限和等待超时, size为0时节点繁忙直接返回错误
+func (s *EdgeSet) SetQueue(size int, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultQueueTimeout
+	}
+	s.RWMutex.Lock()
+	defer s.RWMutex.Unlock()
+	s.queueSize = size
+	s.queueTimeout = timeout
+}
+
+// SetEdgeGracePeriod 没有可用节点在线时请求最多等待多久, 等到节点上线后立即分发, 为0时直接返回错误.
+// size为等待节点上线的请求数上限, 不受SetQueue的size限制, 为0时使用DefaultQueueSize
+func (s *EdgeSet) SetEdgeGracePeriod(grace time.Duration, size int) {
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	s.RWMutex.Lock()
+	defer s.RWMutex.Unlock()
+	s.edgeGrace = grace
+	s.edgeWaitSize = size
+}
+
+// QueueLen 正在排队等待空闲节点的请求数
+func (s *EdgeSet) QueueLen() int {
+	s.RWMutex.RLock()
+	defer s.RWMutex.RUnlock()
+	return s.queue.Len()
+}
+
+// pickEdge 选出一个边缘节点并占用一个并发名额, 使用完毕后需要release.
+// 所有可用节点都达到并发上限, 或开启了等待节点上线而没有可用节点在线时, 按先进先出排队等待, ctx结束时放弃排队
+func (s *EdgeSet) pickEdge(ctx context.Context, options *DispatchOptions) (*Edge, error) {
+	s.RWMutex.Lock()
+	edge, e := s.selectLocked(options)
+	if edge != nil {
+		s.RWMutex.Unlock()
+		return edge, nil
+	}
+	timeout := s.queueTimeout
+	var timeoutError error = errors.NewBusinessError(errcode.ErrorEdgeQueueTimeout, "等待空闲边缘节点超时")
+	if options != nil && options.noWait && e == nil {
+		s.RWMutex.Unlock()
+		return nil, errors.NewBusinessError(errcode.ErrorEdgeQueueFull, "边缘节点繁忙")
+	}
+	offline := e != nil
+	if offline {
+		if s.edgeGrace <= 0 || !isOffline(e) || options != nil && options.noWait {
+			s.RWMutex.Unlock()
+			return nil, e
+		}
+		if s.edgeWaiters >= s.edgeWaitSize {
+			s.RWMutex.Unlock()
+			return nil, errors.NewBusinessError(errcode.ErrorEdgeQueueFull, "等待边缘节点上线的请求已满").WithInnerError(e)
+		}
+		timeout = s.edgeGrace
+		timeoutError = errors.NewBusinessError(errcode.ErrorNoEdge, "等待边缘节点上线超时").WithInnerError(e)
+	} else if s.queue.Len()-s.edgeWaiters >= s.queueSize {
+		s.RWMutex.Unlock()
+		return nil, errors.NewBusinessError(errcode.ErrorEdgeQueueFull, "边缘节点繁忙, 排队的请求已满")
+	}
+	w := &waiter{options: options, enqueuedAt: time.Now(), edge: make(chan *Edge, 1), offline: offline}
+	element := s.queue.PushBack(w)
+	if offline {
+		s.edgeWaiters++
+	}
+	s.RWMutex.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case edge = <-w.edge:
+		return edge, nil
+	case <-timer.C:
+	case <-ctx.Done():
+		timeoutError = ctx.Err()
+	}
+	s.RWMutex.Lock()
+	if !w.assigned {
+		s.removeWaiterLocked(element)
+		s.RWMutex.Unlock()
+		return nil, timeoutError
+	}
+	s.RWMutex.Unlock()
+	// 超时的同时已被分配了节点
+	edge = <-w.edge
+	if ctx.Err() != nil {
+		s.release(edge)
+		return nil, ctx.Err()
+	}
+	return edge, nil
+}
+
+// isOffline 判断是否因为没有在线的节点(或没有匹配标签的在线节点)而无法分发
+func isOffline(e error) bool {
+	var businessError *errors.BusinessError
+	if !goerrors.As(e, &businessError) {
+		return false
+	}
+	return businessError.Code == errcode.ErrorNoEdge || businessError.Code == errcode.ErrorNoMatchingEdge
+}
+
+// release 归还节点的并发名额, 并分配给排队的请求
+func (s *EdgeSet) release(edge *Edge) {
+	edge.InFlight.Add(-1)
+	if edge.Info.MaxInFlight <= 0 {
+		return
+	}
+	s.RWMutex.Lock()
+	defer s.RWMutex.Unlock()
+	s.serveWaitersLocked()
+}
+
+// serveWaitersLocked 按优先级为等待的请求分配节点, 同一优先级先进先出.
+// 排在前面的请求不能使用空闲节点时不阻塞后面的请求
+func (s *EdgeSet) serveWaitersLocked() {
+	if s.queue.Len() == 0 {
+		return
+	}
+	now := time.Now()
+	elements := make([]*list.Element, 0, s.queue.Len())
+	for e := s.queue.Front(); e != nil; e = e.Next() {
+		elements = append(elements, e)
+	}
+	// 队列按到达顺序排列, 稳定排序后同一优先级内保持先进先出
+	slices.SortStableFunc(elements, func(a, b *list.Element) int {
+		return cmp.Compare(s.effectivePriority(b.Value.(*waiter), now), s.effectivePriority(a.Value.(*waiter), now))
+	})
+	for _, e := range elements {
+		w := e.Value.(*waiter)
+		// 出错(如节点已全部下线)的请求继续排队直到超时
+		if edge, err := s.selectLocked(w.options); err == nil && edge != nil {
+			s.removeWaiterLocked(e)
+			w.assigned = true
+			w.edge <- edge
+		}
+	}
+}
+
+func (s *EdgeSet) removeWaiterLocked(element *list.Element) {
+	if s.queue.Remove(element).(*waiter).offline {
+		s.edgeWaiters--
+	}
+}
+
+// selectLocked 选出一个有空闲名额的节点并占用, 带会话键时优先使用会话固定的节点.
+// 满足条件的节点都达到并发上限时返回nil, nil
+func (s *EdgeSet) selectLocked(options *DispatchOptions) (*Edge, error) {
+	if len(s.edges) == 0 {
+		return nil, errors.NewBusinessError(errcode.ErrorNoEdge, "边缘节点为空")
+	}
+	candidates := s.edges
+	if labels := options.labels(); len(labels) > 0 {
+		candidates = make([]*Edge, 0, len(s.edges))
+		for _, edge := range s.edges {
+			if edge.Match(labels) {
+				candidates = append(candidates, edge)
+			}
+		}
+		if len(candidates) == 0 {
+			return nil, errors.NewBusinessError(errcode.ErrorNoMatchingEdge,
+				"没有匹配标签的边缘节点: "+transport.FormatLabels(labels))
+		}
+	}
+	now := time.Now()
+	policy := s.healthPolicy.Load()
+	candidates = slices.DeleteFunc(slices.Clone(candidates), func(edge *Edge) bool {
+		return !edge.health.available(policy, now)
+	})
+	if len(candidates) == 0 {
+		return nil, errors.NewBusinessError(errcode.ErrorEdgeCircuitOpen, "可用的边缘节点都处于熔断状态")
+	}
+	if options != nil && len(options.ExcludeEdges) > 0 {
+		candidates = slices.DeleteFunc(candidates, func(edge *Edge) bool {
+			return options.excluded(edge.EdgeId)
+		})
+		if len(candidates) == 0 {
+			return nil, errors.NewBusinessError(errcode.ErrorEdgeExhausted, "没有尚未尝试过的边缘节点")
+		}
+	}
+
+	key := options.sessionKey()
+	var edge *Edge
+	if key != "" {
+		// 固定的节点不满足标签、已熔断或已尝试过时重新选择, 繁忙时等待
+		edge = s.affinity.lookup(key, candidates)
+		if edge != nil && !edge.idle() {
+			return nil, nil
+		}
+	}
+	if edge == nil {
+		candidates = slices.DeleteFunc(candidates, func(edge *Edge) bool {
+			return !edge.idle()
+		})
+		if len(candidates) == 0 {
+			return nil, nil
+		}
+		edge = s.selector.Select(candidates)
+		if key != "" {
+			s.affinity.pin(key, edge.EdgeId)
+		}
+	}
+	edge.health.acquire(policy, now)
+	edge.InFlight.Add(1)
+	edge.LastUsedAt = now
+	return edge, nil
+}
@@ -0,0 +1,267 @@
+package edge
+
+import (
+	"asyncProxy/constant/errcode"
+	"asyncProxy/errors"
+	"context"
+	goerrors "errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// errorCode 返回业务错误的错误码, 不是业务错误时返回0
+func errorCode(e error) int {
+	var businessError *errors.BusinessError
+	if goerrors.As(e, &businessError) {
+		return businessError.Code
+	}
+	return 0
+}
+
+// pickResult 排队请求的结果
+type pickResult struct {
+	name string
+	edge *Edge
+	err  error
+}
+
+// enqueue 在后台排队并等待请求进入队列, 保证调用顺序即排队顺序
+func enqueue(t *testing.T, s *EdgeSet, ctx context.Context, name string, options *DispatchOptions, results chan<- pickResult) {
+	t.Helper()
+	before := s.QueueLen()
+	go func() {
+		edge, e := s.pickEdge(ctx, options)
+		results <- pickResult{name: name, edge: edge, err: e}
+	}()
+	eventually(t, name+" not queued", func() bool { return s.QueueLen() == before+1 })
+}
+
+func TestQueueFifo(t *testing.T) {
+	s := NewEdgeSet()
+	edge := testEdge("a", 0, 0, 0, time.Now())
+	edge.Info.MaxInFlight = 1
+	addEdges(s, edge)
+	busy, e := s.pickEdge(context.Background(), nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	results := make(chan pickResult, 3)
+	for _, name := range []string{"first", "second", "third"} {
+		enqueue(t, s, context.Background(), name, nil, results)
+	}
+	s.release(busy)
+	for _, want := range []string{"first", "second", "third"} {
+		select {
+		case got := <-results:
+			if got.err != nil || got.name != want {
+				t.Fatalf("got %s (%v), want %s", got.name, got.err, want)
+			}
+			// 分配的请求已占用名额, 释放后才轮到下一个
+			if n := edge.InFlight.Load(); n != 1 {
+				t.Fatalf("in flight = %d", n)
+			}
+			s.release(got.edge)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s not served", want)
+		}
+	}
+	if s.QueueLen() != 0 || edge.InFlight.Load() != 0 {
+		t.Fatalf("queue %d, in flight %d", s.QueueLen(), edge.InFlight.Load())
+	}
+}
+
+func TestQueueRemovesWaiter(t *testing.T) {
+	tests := []struct {
+		name string
+		// cancel 请求进入队列后取消ctx, 否则等待排队超时
+		cancel   bool
+		wantCode int
+	}{
+		{"queue timeout", false, errcode.ErrorEdgeQueueTimeout},
+		{"cancel", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewEdgeSet()
+			s.SetQueue(8, 100*time.Millisecond)
+			edge := testEdge("a", 0, 0, 0, time.Now())
+			edge.Info.MaxInFlight = 1
+			addEdges(s, edge)
+			busy, _ := s.pickEdge(context.Background(), nil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			results := make(chan pickResult, 1)
+			enqueue(t, s, ctx, tt.name, nil, results)
+			if tt.cancel {
+				cancel()
+			}
+			got := <-results
+			if got.edge != nil || errorCode(got.err) != tt.wantCode || tt.cancel && !goerrors.Is(got.err, context.Canceled) {
+				t.Fatalf("got %v, %v", edgeIdOrNil(got.edge), got.err)
+			}
+			if s.QueueLen() != 0 {
+				t.Fatalf("waiter left in queue: %d", s.QueueLen())
+			}
+			// 离开队列的请求不会再被分配名额
+			s.release(busy)
+			if n := edge.InFlight.Load(); n != 0 {
+				t.Fatalf("in flight = %d", n)
+			}
+		})
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	tests := []struct {
+		name      string
+		queueSize int
+		// queued 排队中的请求数
+		queued int
+	}{
+		{"no queue", 0, 0},
+		{"queue full", 2, 2},
+		{"room in queue waits", 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewEdgeSet()
+			s.SetQueue(tt.queueSize, 50*time.Millisecond)
+			edge := testEdge("a", 0, 0, 0, time.Now())
+			edge.Info.MaxInFlight = 1
+			addEdges(s, edge)
+			busy, _ := s.pickEdge(context.Background(), nil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			results := make(chan pickResult, tt.queued)
+			for i := 0; i < tt.queued; i++ {
+				enqueue(t, s, ctx, "queued", nil, results)
+			}
+			full := tt.queued >= tt.queueSize
+			if _, e := s.pickEdge(ctx, nil); full && errorCode(e) != errcode.ErrorEdgeQueueFull ||
+				!full && errorCode(e) != errcode.ErrorEdgeQueueTimeout {
+				t.Fatalf("error = %v", e)
+			}
+			cancel()
+			for i := 0; i < tt.queued; i++ {
+				<-results
+			}
+			s.release(busy)
+		})
+	}
+}
+
+func TestQueueEdgeWaiters(t *testing.T) {
+	s := NewEdgeSet()
+	// 繁忙队列为0时等待节点上线的请求仍然可以排队
+	s.SetQueue(0, time.Second)
+	s.SetEdgeGracePeriod(time.Second, 2)
+	edgeWaiters := func() int {
+		s.RLock()
+		defer s.RUnlock()
+		return s.edgeWaiters
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan pickResult, 3)
+	enqueue(t, s, ctx, "offline 1", nil, results)
+	enqueue(t, s, context.Background(), "offline 2", nil, results)
+	if n := edgeWaiters(); n != 2 {
+		t.Fatalf("edge waiters = %d", n)
+	}
+	if _, e := s.pickEdge(context.Background(), nil); errorCode(e) != errcode.ErrorEdgeQueueFull {
+		t.Fatalf("third offline waiter: %v", e)
+	}
+	// 放弃等待后名额归还
+	cancel()
+	if got := <-results; !goerrors.Is(got.err, context.Canceled) {
+		t.Fatalf("cancelled waiter: %v", got.err)
+	}
+	if n := edgeWaiters(); n != 1 {
+		t.Fatalf("edge waiters after cancel = %d", n)
+	}
+
+	edge := testEdge("a", 0, 0, 0, time.Now())
+	edge.Info.MaxInFlight = 1
+	addEdges(s, edge)
+	got := <-results
+	if got.err != nil || got.edge != edge {
+		t.Fatalf("offline waiter after edge joined: %v, %v", edgeIdOrNil(got.edge), got.err)
+	}
+	if n := edgeWaiters(); n != 0 || s.QueueLen() != 0 {
+		t.Fatalf("edge waiters %d, queue %d", n, s.QueueLen())
+	}
+	// 节点在线后繁忙的请求受繁忙队列的上限限制
+	if _, e := s.pickEdge(context.Background(), nil); errorCode(e) != errcode.ErrorEdgeQueueFull {
+		t.Fatalf("busy request with queue size 0: %v", e)
+	}
+	s.release(got.edge)
+}
+
+func TestEdgeGracePeriod(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *DispatchOptions
+		// join 在宽限期内上线的节点标签, 为nil时没有节点上线
+		join     map[string]string
+		wantCode int
+	}{
+		{"edge joins", nil, map[string]string{}, 0},
+		{"matching edge joins", &DispatchOptions{Labels: map[string]string{"region": "cn-east"}},
+			map[string]string{"region": "cn-east"}, 0},
+		{"grace expires", nil, nil, errcode.ErrorNoEdge},
+		{"only other edges join", &DispatchOptions{Labels: map[string]string{"region": "cn-east"}},
+			map[string]string{"region": "us-west"}, errcode.ErrorNoEdge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewEdgeSet()
+			s.SetEdgeGracePeriod(300*time.Millisecond, 0)
+			results := make(chan error, 1)
+			startAt := time.Now()
+			go func() {
+				response, e := s.DispatchRequestContext(context.Background(), &Request{Method: http.MethodGet,
+					Url: "http://example.com", Timeout: time.Second, Options: tt.options})
+				if e == nil && !response.Success {
+					e = goerrors.New(response.ErrorMessage)
+				}
+				results <- e
+			}()
+			eventually(t, "request not waiting for edge", func() bool { return s.QueueLen() == 1 })
+
+			var fake *fakeEdge
+			if tt.join != nil {
+				fake = addFakeEdge(t, s, EdgeInfo{Labels: tt.join}, replyStatus(http.StatusOK))
+			}
+			e := <-results
+			if errorCode(e) != tt.wantCode || tt.wantCode == 0 && e != nil {
+				t.Fatalf("error = %v", e)
+			}
+			if elapsed := time.Since(startAt); tt.wantCode != 0 && elapsed < 300*time.Millisecond {
+				t.Fatalf("gave up after %s", elapsed)
+			}
+			s.RLock()
+			edgeWaiters := s.edgeWaiters
+			s.RUnlock()
+			if s.QueueLen() != 0 || edgeWaiters != 0 {
+				t.Fatalf("queue %d, edge waiters %d", s.QueueLen(), edgeWaiters)
+			}
+			if fake != nil {
+				waitIdle(t, fake)
+			}
+		})
+	}
+}
+
+func TestEdgeGracePeriodDisabled(t *testing.T) {
+	s := NewEdgeSet()
+	startAt := time.Now()
+	if _, e := s.pickEdge(context.Background(), nil); errorCode(e) != errcode.ErrorNoEdge {
+		t.Fatalf("error = %v", e)
+	}
+	if elapsed := time.Since(startAt); elapsed > 100*time.Millisecond || s.QueueLen() != 0 {
+		t.Fatalf("waited %s, queue %d", elapsed, s.QueueLen())
+	}
+}
@@ -0,0 +1,210 @@
+package edge
+
+import (
+	"asyncProxy/ws/transport"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 可重试的失败类型
+const (
+	// FailureEdgeError 边缘节点请求目标失败
+	FailureEdgeError = "edge_error"
+	// FailureTimeout 单次尝试超时
+	FailureTimeout = "timeout"
+	// FailureDisconnect 边缘节点在请求过程中断开
+	FailureDisconnect = "disconnect"
+	// FailureStatus 目标返回了RetryPolicy.StatusCodes中的状态码
+	FailureStatus = "status"
+)
+
+// DefaultAttemptTimeout 单次尝试的默认超时
+const DefaultAttemptTimeout = 30 * time.Second
+
+// retryBodyLimit 开启重试时不超过该长度的请求体会被缓存, 以便在其他节点重新发送
+const retryBodyLimit = 1024 * 1024
+
+// idempotentMethods 未配置Methods时只重试幂等方法
+var idempotentMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace,
+}
+
+// RetryPolicy 请求失败后换一个边缘节点重试的策略
+type RetryPolicy struct {
+	// MaxAttempts 最多尝试次数, 小于等于1时不重试
+	MaxAttempts int
+	// On 可重试的失败类型, 为空时重试edge_error、timeout、disconnect
+	On []string
+	// StatusCodes 目标返回这些状态码时也重试
+	StatusCodes []int
+	// Methods 可重试的请求方法, 为空时只重试幂等方法
+	Methods []string
+	// AttemptTimeout 单次尝试的超时, 为0时使用DefaultAttemptTimeout
+	AttemptTimeout time.Duration
+}
+
+// Attempt 一次尝试的记录
+type Attempt struct {
+	EdgeId string
+	// Result 状态码或失败类型
+	Result  string
+	Elapsed time.Duration
+}
+
+func (a Attempt) String() string {
+	return fmt.Sprintf("%s %s %dms", a.EdgeId, a.Result, a.Elapsed.Milliseconds())
+}
+
+// FormatAttempts 把尝试记录格式化为响应头的值
+func FormatAttempts(attempts []Attempt) string {
+	values := make([]string, 0, len(attempts))
+	for _, attempt := range attempts {
+		values = append(values, attempt.String())
+	}
+	return strings.Join(values, ", ")
+}
+
+// Validate 检查配置的失败类型是否合法
+func (p *RetryPolicy) Validate() error {
+	for _, failure := range p.On {
+		switch failure {
+		case FailureEdgeError, FailureTimeout, FailureDisconnect:
+		default:
+			return fmt.Errorf("unknown retry failure: %s", failure)
+		}
+	}
+	return nil
+}
+
+func (p *RetryPolicy) attemptTimeout() time.Duration {
+	if p == nil || p.AttemptTimeout <= 0 {
+		return DefaultAttemptTimeout
+	}
+	return p.AttemptTimeout
+}
+
+// Enabled 判断该请求方法的请求是否会重试
+func (p *RetryPolicy) Enabled(method string) bool {
+	return p != nil && p.MaxAttempts > 1 && methodAllowed(p.Methods, method)
+}
+
+// methodAllowed methods为空时只允许幂等方法
+func methodAllowed(methods []string, method string) bool {
+	if len(methods) == 0 {
+		methods = idempotentMethods
+	}
+	return slices.ContainsFunc(methods, func(m string) bool { return strings.EqualFold(m, method) })
+}
+
+// failure 返回响应的失败类型, 成功时返回空字符串
+func (p *RetryPolicy) failure(response *transport.WebsocketProxyResponse) string {
+	switch {
+	case response.Success && p != nil && slices.Contains(p.StatusCodes, response.StatusCode):
+		return FailureStatus
+	case response.Success:
+		return ""
+	case response.ErrorMessage == ErrorMessageTimeout:
+		return FailureTimeout
+	case response.ErrorMessage == ErrorMessageEdgeDisconnected:
+		return FailureDisconnect
+	default:
+		return FailureEdgeError
+	}
+}
+
+func (p *RetryPolicy) retryable(failure string) bool {
+	if failure == FailureStatus {
+		return true
+	}
+	if p == nil || len(p.On) == 0 {
+		return failure == FailureEdgeError || failure == FailureTimeout || failure == FailureDisconnect
+	}
+	return slices.Contains(p.On, failure)
+}
+
+// bufferBody 缓存Content-Length不超过retryBodyLimit的请求体, 使其可以重复发送
+func bufferBody(headers map[string][]string, body io.Reader) (io.Reader, error) {
+	if _, ok := replayable(body); ok {
+		return body, nil
+	}
+	if length := requestContentLength(headers, body); length <= 0 || length > retryBodyLimit {
+		return body, nil
+	}
+	b, e := io.ReadAll(body)
+	if e != nil {
+		return nil, e
+	}
+	return bytes.NewReader(b), nil
+}
+
+// DispatchRequestWithRetry 分发请求并等待响应头, 失败时按策略换一个没有尝试过的节点重试.
+// body为nil或可以重复读取(实现io.ReaderAt和Size, 如*bytes.Reader)时才会重试, 其他请求体不超过1MB时先缓存
+func (s *EdgeSet) DispatchRequestWithRetry(ctx context.Context, method, url string, headers map[string][]string, body io.Reader,
+	policy *RetryPolicy, options *DispatchOptions) (*transport.WebsocketProxyResponse, []Attempt, error) {
+	if policy.Enabled(method) {
+		var e error
+		if body, e = bufferBody(headers, body); e != nil {
+			return nil, nil, e
+		}
+	}
+	replayableBody, canReplay := replayable(body)
+	maxAttempts := 1
+	if policy.Enabled(method) && canReplay {
+		maxAttempts = policy.MaxAttempts
+	}
+
+	var attempts []Attempt
+	var last *transport.WebsocketProxyResponse
+	attemptOptions := DispatchOptions{}
+	if options != nil {
+		attemptOptions = *options
+	}
+	for len(attempts) < maxAttempts {
+		attemptBody := body
+		if canReplay {
+			attemptBody = newBodyReader(replayableBody)
+		}
+		startAt := time.Now()
+		response, e := s.DispatchRequestContext(ctx, &Request{
+			Method:  method,
+			Url:     url,
+			Headers: headers,
+			Body:    attemptBody,
+			Timeout: policy.attemptTimeout(),
+			Options: &attemptOptions,
+		})
+		if e != nil {
+			if last != nil && ctx.Err() == nil {
+				// 没有其他可用节点, 返回最后一次的结果
+				return last, attempts, nil
+			}
+			if last != nil && last.BodyReader != nil {
+				_ = last.BodyReader.Close()
+			}
+			return nil, attempts, e
+		}
+		if last != nil && last.BodyReader != nil {
+			_ = last.BodyReader.Close()
+		}
+		last = response
+
+		failure := policy.failure(response)
+		result := failure
+		if failure == "" || failure == FailureStatus {
+			result = strconv.Itoa(response.StatusCode)
+		}
+		attempts = append(attempts, Attempt{EdgeId: response.EdgeId, Result: result, Elapsed: time.Since(startAt)})
+		if failure == "" || !policy.retryable(failure) {
+			break
+		}
+		attemptOptions.ExcludeEdges = append(slices.Clone(attemptOptions.ExcludeEdges), response.EdgeId)
+	}
+	return last, attempts, nil
+}
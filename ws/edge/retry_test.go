@@ -0,0 +1,99 @@
+package edge
+
+import (
+	"asyncProxy/ws/transport"
+	"testing"
+)
+
+func TestRetryPolicyFailure(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, StatusCodes: []int{502, 503}}
+	tests := []struct {
+		name     string
+		policy   *RetryPolicy
+		response transport.WebsocketProxyResponse
+		want     string
+	}{
+		{"success", policy, transport.WebsocketProxyResponse{Success: true, StatusCode: 200}, ""},
+		{"retry status", policy, transport.WebsocketProxyResponse{Success: true, StatusCode: 503}, FailureStatus},
+		{"other status", policy, transport.WebsocketProxyResponse{Success: true, StatusCode: 500}, ""},
+		{"nil policy status", nil, transport.WebsocketProxyResponse{Success: true, StatusCode: 503}, ""},
+		{"timeout", policy, transport.WebsocketProxyResponse{ErrorMessage: ErrorMessageTimeout}, FailureTimeout},
+		{"disconnect", policy, transport.WebsocketProxyResponse{ErrorMessage: ErrorMessageEdgeDisconnected}, FailureDisconnect},
+		{"edge error", policy, transport.WebsocketProxyResponse{ErrorMessage: "dial tcp: connection refused"}, FailureEdgeError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.failure(&tt.response); got != tt.want {
+				t.Fatalf("failure = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *RetryPolicy
+		failure string
+		want    bool
+	}{
+		{"default edge error", &RetryPolicy{}, FailureEdgeError, true},
+		{"default timeout", &RetryPolicy{}, FailureTimeout, true},
+		{"default disconnect", &RetryPolicy{}, FailureDisconnect, true},
+		{"nil policy", nil, FailureTimeout, true},
+		{"status always", &RetryPolicy{On: []string{FailureTimeout}}, FailureStatus, true},
+		{"configured", &RetryPolicy{On: []string{FailureTimeout}}, FailureTimeout, true},
+		{"not configured", &RetryPolicy{On: []string{FailureTimeout}}, FailureEdgeError, false},
+		{"unknown", &RetryPolicy{}, "unknown", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.retryable(tt.failure); got != tt.want {
+				t.Fatalf("retryable(%q) = %v, want %v", tt.failure, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyEnabled(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *RetryPolicy
+		method string
+		want   bool
+	}{
+		{"nil policy", nil, "GET", false},
+		{"single attempt", &RetryPolicy{MaxAttempts: 1}, "GET", false},
+		{"idempotent", &RetryPolicy{MaxAttempts: 2}, "GET", true},
+		{"idempotent put", &RetryPolicy{MaxAttempts: 2}, "PUT", true},
+		{"not idempotent", &RetryPolicy{MaxAttempts: 2}, "POST", false},
+		{"configured methods", &RetryPolicy{MaxAttempts: 2, Methods: []string{"post"}}, "POST", true},
+		{"not in configured methods", &RetryPolicy{MaxAttempts: 2, Methods: []string{"POST"}}, "GET", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Enabled(tt.method); got != tt.want {
+				t.Fatalf("Enabled(%q) = %v, want %v", tt.method, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	tests := []struct {
+		on      []string
+		wantErr bool
+	}{
+		{nil, false},
+		{[]string{FailureEdgeError, FailureTimeout, FailureDisconnect}, false},
+		// status由StatusCodes控制, 不能出现在On中
+		{[]string{FailureStatus}, true},
+		{[]string{"reset"}, true},
+	}
+	for _, tt := range tests {
+		policy := &RetryPolicy{On: tt.on}
+		if e := policy.Validate(); (e != nil) != tt.wantErr {
+			t.Fatalf("Validate(%v) error = %v", tt.on, e)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package edge
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync/atomic"
+)
+
+// Selector 从候选边缘节点中选出一个处理请求, edges不为空
+type Selector interface {
+	Select(edges []*Edge) *Edge
+}
+
+const (
+	SelectorRoundRobin    = "round_robin"
+	SelectorRandom        = "random"
+	SelectorLeastInFlight = "least_in_flight"
+	SelectorWeighted      = "weighted"
+	SelectorLowestRtt     = "lowest_rtt"
+)
+
+// NewSelector 根据名称创建选择策略, 名称为空时使用轮询
+func NewSelector(name string) (Selector, error) {
+	switch name {
+	case "", SelectorRoundRobin:
+		return &RoundRobinSelector{}, nil
+	case SelectorRandom:
+		return RandomSelector{}, nil
+	case SelectorLeastInFlight:
+		return LeastInFlightSelector{}, nil
+	case SelectorWeighted:
+		return WeightedSelector{}, nil
+	case SelectorLowestRtt:
+		return LowestRttSelector{}, nil
+	default:
+		return nil, fmt.Errorf("unknown edge selector: %s", name)
+	}
+}
+
+// RoundRobinSelector 依次轮流选择
+type RoundRobinSelector struct {
+	counter atomic.Uint64
+}
+
+func (r *RoundRobinSelector) Select(edges []*Edge) *Edge {
+	n := r.counter.Add(1) - 1
+	return edges[n%uint64(len(edges))]
+}
+
+// RandomSelector 随机选择
+type RandomSelector struct{}
+
+func (RandomSelector) Select(edges []*Edge) *Edge {
+	return edges[rand.Intn(len(edges))]
+}
+
+// LeastInFlightSelector 选择处理中请求最少的节点, 相同时选择最久未使用的
+type LeastInFlightSelector struct{}
+
+func (LeastInFlightSelector) Select(edges []*Edge) *Edge {
+	return slices.MinFunc(edges, func(a, b *Edge) int {
+		if c := cmp.Compare(a.InFlight.Load(), b.InFlight.Load()); c != 0 {
+			return c
+		}
+		return a.LastUsedAt.Compare(b.LastUsedAt)
+	})
+}
+
+// WeightedSelector 按节点声明的权重随机选择
+type WeightedSelector struct{}
+
+func (WeightedSelector) Select(edges []*Edge) *Edge {
+	total := 0
+	for _, edge := range edges {
+		total += edge.weight()
+	}
+	n := rand.Intn(total)
+	for _, edge := range edges {
+		n -= edge.weight()
+		if n < 0 {
+			return edge
+		}
+	}
+	return edges[len(edges)-1]
+}
+
+// LowestRttSelector 选择心跳往返时间最短的节点, 尚未测得RTT的节点排在最后
+type LowestRttSelector struct{}
+
+func (LowestRttSelector) Select(edges []*Edge) *Edge {
+	return slices.MinFunc(edges, func(a, b *Edge) int {
+		ra, rb := a.Rtt(), b.Rtt()
+		if (ra == 0) != (rb == 0) {
+			if ra == 0 {
+				return 1
+			}
+			return -1
+		}
+		if c := cmp.Compare(ra, rb); c != 0 {
+			return c
+		}
+		return a.LastUsedAt.Compare(b.LastUsedAt)
+	})
+}
@@ -0,0 +1,132 @@
+package edge
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testEdge 创建用于选择策略测试的节点
+func testEdge(id string, inFlight int64, weight int, rtt time.Duration, lastUsed time.Time) *Edge {
+	edge := &Edge{EdgeId: id, LastUsedAt: lastUsed, Info: EdgeInfo{Weight: weight}}
+	edge.InFlight.Store(inFlight)
+	edge.rtt.Store(int64(rtt))
+	return edge
+}
+
+func TestNewSelector(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"", "*edge.RoundRobinSelector", false},
+		{SelectorRoundRobin, "*edge.RoundRobinSelector", false},
+		{SelectorRandom, "edge.RandomSelector", false},
+		{SelectorLeastInFlight, "edge.LeastInFlightSelector", false},
+		{SelectorWeighted, "edge.WeightedSelector", false},
+		{SelectorLowestRtt, "edge.LowestRttSelector", false},
+		{"fastest", "", true},
+	}
+	for _, tt := range tests {
+		selector, e := NewSelector(tt.name)
+		if (e != nil) != tt.wantErr {
+			t.Fatalf("NewSelector(%q) error = %v", tt.name, e)
+		}
+		if got := fmt.Sprintf("%T", selector); e == nil && got != tt.want {
+			t.Fatalf("NewSelector(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRoundRobinSelector(t *testing.T) {
+	now := time.Now()
+	edges := []*Edge{testEdge("a", 0, 0, 0, now), testEdge("b", 0, 0, 0, now), testEdge("c", 0, 0, 0, now)}
+	selector := &RoundRobinSelector{}
+	for i, want := range []string{"a", "b", "c", "a", "b"} {
+		if got := selector.Select(edges).EdgeId; got != want {
+			t.Fatalf("select #%d = %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestRandomSelector(t *testing.T) {
+	now := time.Now()
+	edges := []*Edge{testEdge("a", 0, 0, 0, now), testEdge("b", 0, 0, 0, now)}
+	seen := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		seen[RandomSelector{}.Select(edges).EdgeId] = true
+	}
+	if !seen["a"] || !seen["b"] {
+		t.Fatalf("random selector only picked %v", seen)
+	}
+}
+
+func TestLeastInFlightSelector(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		edges []*Edge
+		want  string
+	}{
+		{"fewest in flight", []*Edge{testEdge("a", 3, 0, 0, now), testEdge("b", 1, 0, 0, now), testEdge("c", 2, 0, 0, now)}, "b"},
+		{"tie picks least recently used", []*Edge{testEdge("a", 1, 0, 0, now), testEdge("b", 1, 0, 0, now.Add(-time.Minute))}, "b"},
+		{"single edge", []*Edge{testEdge("a", 9, 0, 0, now)}, "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (LeastInFlightSelector{}).Select(tt.edges).EdgeId; got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeightedSelector(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		edges []*Edge
+		// want 各节点被选中的大致比例
+		want map[string]float64
+	}{
+		{"by weight", []*Edge{testEdge("a", 0, 3, 0, now), testEdge("b", 0, 1, 0, now)}, map[string]float64{"a": 0.75, "b": 0.25}},
+		{"undeclared weight counts as one", []*Edge{testEdge("a", 0, 0, 0, now), testEdge("b", 0, 1, 0, now)}, map[string]float64{"a": 0.5, "b": 0.5}},
+	}
+	const rounds = 4000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts := map[string]int{}
+			for i := 0; i < rounds; i++ {
+				counts[(WeightedSelector{}).Select(tt.edges).EdgeId]++
+			}
+			for id, ratio := range tt.want {
+				got := float64(counts[id]) / rounds
+				if got < ratio-0.05 || got > ratio+0.05 {
+					t.Fatalf("edge %s picked %.2f, want about %.2f", id, got, ratio)
+				}
+			}
+		})
+	}
+}
+
+func TestLowestRttSelector(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		edges []*Edge
+		want  string
+	}{
+		{"lowest rtt", []*Edge{testEdge("a", 0, 0, 30*time.Millisecond, now), testEdge("b", 0, 0, 10*time.Millisecond, now)}, "b"},
+		{"unmeasured last", []*Edge{testEdge("a", 0, 0, 0, now), testEdge("b", 0, 0, time.Second, now)}, "b"},
+		{"all unmeasured picks least recently used", []*Edge{testEdge("a", 0, 0, 0, now), testEdge("b", 0, 0, 0, now.Add(-time.Minute))}, "b"},
+		{"tie picks least recently used", []*Edge{testEdge("a", 0, 0, time.Millisecond, now), testEdge("b", 0, 0, time.Millisecond, now.Add(-time.Minute))}, "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (LowestRttSelector{}).Select(tt.edges).EdgeId; got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package edge
+
+import (
+	"asyncProxy/constant/errcode"
+	"asyncProxy/errors"
+	"asyncProxy/ws/transport"
+	"context"
+	goerrors "errors"
+	"github.com/oklog/ulid/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"time"
+)
+
+type tunnelCallback struct {
+	EdgeId string
+	Result chan error
+}
+
+// OpenTunnel 在边缘节点上连接目标地址, 返回的流原样转发双方的字节, 使用完毕后需要Close
+func (s *EdgeSet) OpenTunnel(network, address string, timeout time.Duration, options *DispatchOptions) (*transport.Stream, string, error) {
+	firstEdge, e := s.pickEdge(context.Background(), options)
+	if e != nil {
+		return nil, "", e
+	}
+
+	streamId := ulid.Make().String()
+	b, e := msgpack.Marshal(&transport.TunnelOpenRequest{
+		Network: network,
+		Address: address,
+		Timeout: timeout.Seconds(),
+		EdgeId:  firstEdge.EdgeId,
+	})
+	if e != nil {
+		s.release(firstEdge)
+		return nil, "", errors.NewBusinessError(errcode.ErrorSerializeOrDeserializeFailed, "隧道请求序列化失败").WithInnerError(e)
+	}
+
+	stream := transport.NewStream(streamId, firstEdge.Conn, func() {
+		s.streams.Delete(streamId)
+		s.release(firstEdge)
+	})
+	s.streams.Store(streamId, stream)
+	callback := &tunnelCallback{
+		EdgeId: firstEdge.EdgeId,
+		Result: make(chan error, 1),
+	}
+	s.tunnels.Store(streamId, callback)
+	defer s.tunnels.Delete(streamId)
+
+	e = transport.WriteFrame(firstEdge.Conn, &transport.Frame{
+		Type:     transport.FrameTypeTunnelOpen,
+		StreamId: streamId,
+		Data:     b,
+	})
+	if e != nil {
+		stream.Abort(e)
+		return nil, "", errors.NewBusinessError(errcode.ErrorEdgeSendMessageFailed, "发送隧道请求到边缘节点失败").WithInnerError(e)
+	}
+
+	timeoutTimer := time.NewTimer(timeout)
+	defer timeoutTimer.Stop()
+	select {
+	case e = <-callback.Result:
+	case <-timeoutTimer.C:
+		e = goerrors.New("tunnel open timeout")
+	}
+	if e != nil {
+		_ = stream.Close()
+		return nil, firstEdge.EdgeId, errors.NewBusinessError(errcode.ErrorTunnelOpenFailed, "边缘节点打开隧道失败").WithInnerError(e)
+	}
+	return stream, firstEdge.EdgeId, nil
+}
+
+// onTunnelFrame 处理隧道建立阶段的应答, 返回false表示该流不在建立阶段
+func (s *EdgeSet) onTunnelFrame(frame *transport.Frame) bool {
+	value, ok := s.tunnels.Load(frame.StreamId)
+	if !ok {
+		return false
+	}
+	callback := value.(*tunnelCallback)
+	switch frame.Type {
+	case transport.FrameTypeTunnelOpened:
+		callback.resolve(nil)
+	case transport.FrameTypeReset:
+		callback.resolve(goerrors.New(frame.Error))
+	default:
+		return false
+	}
+	return true
+}
+
+func (c *tunnelCallback) resolve(err error) {
+	select {
+	case c.Result <- err:
+	default:
+	}
+}
@@ -0,0 +1,163 @@
+package ws
+
+import (
+	"asyncProxy/constant"
+	"asyncProxy/ws/edge"
+	"asyncProxy/ws/transport"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// EdgeError 边缘节点返回的失败结果, 如请求目标失败、超时或节点断开
+type EdgeError struct {
+	EdgeId    string
+	RequestId string
+	Message   string
+}
+
+func (e *EdgeError) Error() string {
+	return fmt.Sprintf("edge %s request %s failed: %s", e.EdgeId, e.RequestId, e.Message)
+}
+
+// Timeout 是否因等待响应超时而失败
+func (e *EdgeError) Timeout() bool {
+	return e.Message == edge.ErrorMessageTimeout
+}
+
+// RoundTripper 通过边缘节点发送请求的http.RoundTripper, 可直接作为http.Client的Transport使用.
+// 响应头X-Async-Edge为处理请求的节点ID, 节点返回的失败结果以*EdgeError返回
+type RoundTripper struct {
+	// EdgeSet 为nil时使用默认的节点集合
+	EdgeSet *edge.EdgeSet
+	// Timeout 等待响应头的超时, 为0时使用请求ctx的截止时间, 开启重试时使用单次尝试的超时
+	Timeout time.Duration
+	// Options 会话和标签等分发选项
+	Options *edge.DispatchOptions
+	// Retry 失败时换节点重试的策略, 为nil时不重试
+	Retry *edge.RetryPolicy
+}
+
+func (t *RoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	var body *requestBody
+	if request.Body != nil && request.Body != http.NoBody {
+		body = &requestBody{ReadCloser: request.Body}
+	}
+	if request.URL == nil || request.URL.Host == "" {
+		body.close()
+		return nil, fmt.Errorf("ws: request url has no host: %v", request.URL)
+	}
+
+	headers := request.Header.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	if request.Host != "" && request.Host != request.URL.Host {
+		headers.Set("Host", request.Host)
+	}
+	// 与net/http相同, 有请求体时ContentLength为0表示长度未知, 按未知长度转发
+	var reader io.Reader
+	if body != nil {
+		reader = body
+		if request.ContentLength > 0 {
+			headers.Set("Content-Length", strconv.FormatInt(request.ContentLength, 10))
+		} else {
+			headers.Del("Content-Length")
+		}
+	}
+
+	edgeSet := t.EdgeSet
+	if edgeSet == nil {
+		edgeSet = EdgeSet
+	}
+	retry := t.Retry.Enabled(request.Method)
+	var wsResponse *transport.WebsocketProxyResponse
+	var attempts []edge.Attempt
+	var e error
+	if retry {
+		policy := *t.Retry
+		if t.Timeout > 0 {
+			policy.AttemptTimeout = t.Timeout
+		}
+		wsResponse, attempts, e = edgeSet.DispatchRequestWithRetry(request.Context(), request.Method, request.URL.String(),
+			headers, reader, &policy, t.Options)
+	} else {
+		wsResponse, e = edgeSet.DispatchRequestContext(request.Context(), &edge.Request{
+			Method:  request.Method,
+			Url:     request.URL.String(),
+			Headers: headers,
+			Body:    reader,
+			Timeout: t.Timeout,
+			Options: t.Options,
+		})
+	}
+	if e != nil {
+		body.close()
+		return nil, e
+	}
+	if !wsResponse.Success {
+		body.close()
+		return nil, &EdgeError{EdgeId: wsResponse.EdgeId, RequestId: wsResponse.RequestId, Message: wsResponse.ErrorMessage}
+	}
+
+	header := http.Header(wsResponse.Headers)
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set(constant.HeaderAsyncEdge, wsResponse.EdgeId)
+	if retry {
+		header.Set(constant.HeaderAsyncAttempts, edge.FormatAttempts(attempts))
+	}
+	var responseBody io.ReadCloser = http.NoBody
+	if wsResponse.BodyReader != nil {
+		responseBody = &responseBodyCloser{ReadCloser: wsResponse.BodyReader, request: body}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", wsResponse.StatusCode, http.StatusText(wsResponse.StatusCode)),
+		StatusCode:    wsResponse.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          responseBody,
+		ContentLength: wsResponse.ContentLength,
+		Request:       request,
+	}, nil
+}
+
+// requestBody 读完请求体或请求结束时关闭, RoundTripper必须关闭请求体
+type requestBody struct {
+	io.ReadCloser
+	once sync.Once
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	n, e := b.ReadCloser.Read(p)
+	if e != nil {
+		b.close()
+	}
+	return n, e
+}
+
+func (b *requestBody) close() {
+	if b == nil {
+		return
+	}
+	b.once.Do(func() {
+		_ = b.ReadCloser.Close()
+	})
+}
+
+// responseBodyCloser 关闭响应体时一并关闭尚未读完的请求体
+type responseBodyCloser struct {
+	io.ReadCloser
+	request *requestBody
+}
+
+func (b *responseBodyCloser) Close() error {
+	b.request.close()
+	return b.ReadCloser.Close()
+}
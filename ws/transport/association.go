@@ -0,0 +1,143 @@
+package transport
+
+import (
+	"github.com/lxzan/gws"
+	"github.com/vmihailenco/msgpack/v5"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// associationQueueSize 未处理的数据报超过该数量时直接丢弃
+const associationQueueSize = 256
+
+// AssociateRequest 打开UDP关联的请求, 边缘节点使用自己的UDP端口收发数据报
+type AssociateRequest struct {
+	IdleTimeout float64 `msgpack:"idleTimeout"`
+	EdgeId      string  `msgpack:"edgeId"`
+}
+
+// Datagram UDP数据报, 发往边缘节点时Address为目标地址, 发回服务端时为来源地址
+type Datagram struct {
+	Address string `msgpack:"address"`
+	Payload []byte `msgpack:"payload"`
+}
+
+// Association 在websocket连接上复用的UDP关联, 数据报不做流控, 队列满时丢弃
+type Association struct {
+	Id      string
+	conn    *gws.Conn
+	onClose func()
+
+	datagrams  chan *Datagram
+	closed     chan struct{}
+	closeOnce  sync.Once
+	lastActive atomic.Int64
+}
+
+func NewAssociation(id string, conn *gws.Conn, onClose func()) *Association {
+	a := &Association{
+		Id:        id,
+		conn:      conn,
+		onClose:   onClose,
+		datagrams: make(chan *Datagram, associationQueueSize),
+		closed:    make(chan struct{}),
+	}
+	a.touch()
+	return a
+}
+
+func (a *Association) Conn() *gws.Conn {
+	return a.conn
+}
+
+// Datagrams 对端发来的数据报
+func (a *Association) Datagrams() <-chan *Datagram {
+	return a.datagrams
+}
+
+// Done 关联关闭后返回
+func (a *Association) Done() <-chan struct{} {
+	return a.closed
+}
+
+// Send 发送数据报给对端
+func (a *Association) Send(datagram *Datagram) error {
+	select {
+	case <-a.closed:
+		return ErrStreamClosed
+	default:
+	}
+	b, e := msgpack.Marshal(datagram)
+	if e != nil {
+		return e
+	}
+	a.touch()
+	return WriteFrame(a.conn, &Frame{Type: FrameTypeDatagram, StreamId: a.Id, Data: b})
+}
+
+// HandleFrame 处理对端发来的数据报/重置帧, 其他类型返回false
+func (a *Association) HandleFrame(frame *Frame) bool {
+	switch frame.Type {
+	case FrameTypeDatagram:
+		var datagram Datagram
+		if e := msgpack.Unmarshal(frame.Data, &datagram); e != nil {
+			return true
+		}
+		a.touch()
+		select {
+		case a.datagrams <- &datagram:
+		default:
+		}
+	case FrameTypeReset:
+		a.Abort()
+	default:
+		return false
+	}
+	return true
+}
+
+// Close 关闭关联并通知对端
+func (a *Association) Close() error {
+	if !a.Abort() {
+		return nil
+	}
+	return WriteFrame(a.conn, &Frame{Type: FrameTypeReset, StreamId: a.Id})
+}
+
+// Abort 仅在本地关闭关联, 返回是否由本次调用关闭
+func (a *Association) Abort() bool {
+	aborted := false
+	a.closeOnce.Do(func() {
+		aborted = true
+		close(a.closed)
+		if a.onClose != nil {
+			a.onClose()
+		}
+	})
+	return aborted
+}
+
+// CloseWhenIdle 超过timeout没有收发数据报时关闭关联
+func (a *Association) CloseWhenIdle(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(min(timeout, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.closed:
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, a.lastActive.Load())) > timeout {
+				_ = a.Close()
+				return
+			}
+		}
+	}
+}
+
+func (a *Association) touch() {
+	a.lastActive.Store(time.Now().UnixNano())
+}
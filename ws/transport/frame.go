@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"github.com/lxzan/gws"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type FrameType uint8
+
+const (
+	// FrameTypeRequest 请求头, 服务端发往边缘节点, Data为WebsocketProxyRequest
+	FrameTypeRequest FrameType = iota + 1
+	// FrameTypeResponse 响应头, 边缘节点发往服务端, Data为WebsocketProxyResponse
+	FrameTypeResponse
+	// FrameTypeData 数据帧, Data为一段消息体
+	FrameTypeData
+	// FrameTypeEnd 结束帧, 发送方不会再发送数据, Error不为空时表示异常结束
+	FrameTypeEnd
+	// FrameTypeWindow 流控帧, 接收方归还Credit个数据帧的发送额度
+	FrameTypeWindow
+	// FrameTypeReset 重置帧, 双向终止整个流. 服务端取消请求(超时、客户端断开)时发送, 边缘节点随即中止请求
+	FrameTypeReset
+	// FrameTypeTunnelOpen 打开隧道, 服务端发往边缘节点, Data为TunnelOpenRequest
+	FrameTypeTunnelOpen
+	// FrameTypeTunnelOpened 边缘节点已连接目标地址, 连接失败时以FrameTypeReset代替
+	FrameTypeTunnelOpened
+	// FrameTypeAssociate 打开UDP关联, 服务端发往边缘节点, Data为AssociateRequest
+	FrameTypeAssociate
+	// FrameTypeDatagram UDP数据报, Data为Datagram, 关闭关联使用FrameTypeReset
+	FrameTypeDatagram
+)
+
+// Frame 服务端与边缘节点之间传输的消息帧, 同一个流的帧使用相同的StreamId(HTTP请求即RequestId)
+type Frame struct {
+	Type     FrameType `msgpack:"type"`
+	StreamId string    `msgpack:"streamId"`
+	Data     []byte    `msgpack:"data,omitempty"`
+	Credit   int       `msgpack:"credit,omitempty"`
+	Error    string    `msgpack:"error,omitempty"`
+}
+
+// WriteFrame 序列化并发送一个消息帧
+func WriteFrame(conn *gws.Conn, frame *Frame) error {
+	b, e := msgpack.Marshal(frame)
+	if e != nil {
+		return e
+	}
+	return conn.WriteMessage(gws.OpcodeBinary, b)
+}
+
+// ReadFrame 从websocket消息中解析消息帧
+func ReadFrame(message *gws.Message) (*Frame, error) {
+	var frame Frame
+	if e := msgpack.Unmarshal(message.Bytes(), &frame); e != nil {
+		return nil, e
+	}
+	return &frame, nil
+}
@@ -0,0 +1,32 @@
+package transport
+
+import (
+	"sort"
+	"strings"
+)
+
+// ParseLabels 解析形如 region=cn-east,isp=telecom 的标签
+func ParseLabels(value string) map[string]string {
+	labels := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		key, val, _ := strings.Cut(pair, "=")
+		if key = strings.TrimSpace(key); key != "" {
+			labels[key] = strings.TrimSpace(val)
+		}
+	}
+	return labels
+}
+
+// FormatLabels 按键排序输出标签, 与ParseLabels互逆
+func FormatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+labels[key])
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,47 @@
+package transport
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		value string
+		want  map[string]string
+	}{
+		{"", map[string]string{}},
+		{"region=cn-east", map[string]string{"region": "cn-east"}},
+		{"region=cn-east,isp=telecom", map[string]string{"region": "cn-east", "isp": "telecom"}},
+		{" region = cn-east , isp=telecom ", map[string]string{"region": "cn-east", "isp": "telecom"}},
+		{"gpu", map[string]string{"gpu": ""}},
+		{"region=a,,=b,region=c", map[string]string{"region": "c"}},
+		{"url=a=b", map[string]string{"url": "a=b"}},
+	}
+	for _, tt := range tests {
+		if got := ParseLabels(tt.value); !maps.Equal(got, tt.want) {
+			t.Fatalf("ParseLabels(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	tests := []struct {
+		labels map[string]string
+		want   string
+	}{
+		{nil, ""},
+		{map[string]string{"region": "cn-east"}, "region=cn-east"},
+		{map[string]string{"region": "cn-east", "isp": "telecom", "gpu": ""}, "gpu=,isp=telecom,region=cn-east"},
+	}
+	for _, tt := range tests {
+		got := FormatLabels(tt.labels)
+		if got != tt.want {
+			t.Fatalf("FormatLabels(%v) = %q, want %q", tt.labels, got, tt.want)
+		}
+		// 与ParseLabels互逆
+		if len(tt.labels) > 0 && !maps.Equal(ParseLabels(got), tt.labels) {
+			t.Fatalf("ParseLabels(FormatLabels(%v)) = %v", tt.labels, ParseLabels(got))
+		}
+	}
+}
@@ -8,4 +8,6 @@ type WebsocketProxyRequest struct {
 	RequestId string              `msgpack:"requestId"`
 	Timeout   float64             `msgpack:"timeout"`
 	EdgeId    string              `msgpack:"edgeId"`
+	// ContentLength 请求体长度, 0表示没有请求体, -1表示长度未知, 请求体通过数据帧发送
+	ContentLength int64 `msgpack:"contentLength"`
 }
@@ -1,11 +1,19 @@
 package transport
 
+import "io"
+
 type WebsocketProxyResponse struct {
-	Success      bool                `msgpack:"success"`
-	ErrorMessage string              `msgpack:"errorMessage"`
-	Headers      map[string][]string `msgpack:"headers"`
-	Body         []byte              `msgpack:"body"`
-	RequestId    string              `msgpack:"requestId"`
-	StatusCode   int                 `msgpack:"statusCode"`
-	EdgeId       string              `msgpack:"edgeId"`
+	Success      bool                `msgpack:"success" json:"success"`
+	ErrorMessage string              `msgpack:"errorMessage" json:"errorMessage"`
+	Headers      map[string][]string `msgpack:"headers" json:"headers"`
+	Body         []byte              `msgpack:"body" json:"body"`
+	RequestId    string              `msgpack:"requestId" json:"requestId"`
+	StatusCode   int                 `msgpack:"statusCode" json:"statusCode"`
+	EdgeId       string              `msgpack:"edgeId" json:"edgeId"`
+	// EdgeFailure 失败是节点自身的故障(本机网络不可用、DNS服务器无响应等)而不是目标的错误, 计入节点健康统计
+	EdgeFailure bool `msgpack:"edgeFailure" json:"edgeFailure"`
+	// ContentLength 响应体长度, -1表示长度未知
+	ContentLength int64 `msgpack:"contentLength" json:"contentLength"`
+	// BodyReader 流式读取的响应体, 仅在服务端有效, 使用完毕后需要Close
+	BodyReader io.ReadCloser `msgpack:"-" json:"-"`
 }
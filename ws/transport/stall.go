@@ -0,0 +1,45 @@
+package transport
+
+import (
+	goerrors "errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBodyStalled 响应体超过空闲时间没有进展
+var ErrBodyStalled = goerrors.New("body stalled")
+
+// StallReader 单次读取超过timeout没有返回时关闭响应体, 中止边缘节点停滞的传输, 不限制传输总时长
+type StallReader struct {
+	io.ReadCloser
+	timer   *time.Timer
+	timeout time.Duration
+	stalled atomic.Bool
+}
+
+func NewStallReader(body io.ReadCloser, timeout time.Duration) *StallReader {
+	r := &StallReader{ReadCloser: body, timeout: timeout}
+	r.timer = time.AfterFunc(timeout, func() {
+		r.stalled.Store(true)
+		_ = body.Close()
+	})
+	r.timer.Stop()
+	return r
+}
+
+// Read 因停滞被关闭时返回ErrBodyStalled
+func (r *StallReader) Read(p []byte) (int, error) {
+	r.timer.Reset(r.timeout)
+	n, e := r.ReadCloser.Read(p)
+	r.timer.Stop()
+	if e != nil && r.stalled.Load() {
+		e = ErrBodyStalled
+	}
+	return n, e
+}
+
+func (r *StallReader) Close() error {
+	r.timer.Stop()
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"bytes"
+	goerrors "errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// slowReader 每次读取前等待delay, 关闭后读取失败
+type slowReader struct {
+	data   []byte
+	delay  time.Duration
+	closed chan struct{}
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	select {
+	case <-time.After(r.delay):
+	case <-r.closed:
+		return 0, ErrStreamClosed
+	}
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p[:1], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func (r *slowReader) Close() error {
+	select {
+	case <-r.closed:
+	default:
+		close(r.closed)
+	}
+	return nil
+}
+
+func TestStallReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		delay   time.Duration
+		wantErr error
+	}{
+		// 总时长超过timeout, 但每次读取都有进展
+		{"slow progress", 20 * time.Millisecond, nil},
+		{"stalled", time.Second, ErrBodyStalled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []byte("0123456789")
+			body := &slowReader{data: bytes.Clone(data), delay: tt.delay, closed: make(chan struct{})}
+			reader := NewStallReader(body, 100*time.Millisecond)
+			got, e := io.ReadAll(reader)
+			if !goerrors.Is(e, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", e, tt.wantErr)
+			}
+			if tt.wantErr == nil && !bytes.Equal(got, data) {
+				t.Fatalf("got %q", got)
+			}
+			_ = reader.Close()
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package transport
+
+import (
+	goerrors "errors"
+	"github.com/lxzan/gws"
+	"io"
+	"sync"
+)
+
+const (
+	// StreamChunkSize 单个数据帧携带的最大字节数
+	StreamChunkSize = 32 * 1024
+	// StreamWindow 接收方最多缓存的未读数据帧数量, 即发送方的初始额度
+	StreamWindow = 64
+)
+
+var (
+	ErrStreamClosed = goerrors.New("stream closed")
+	ErrStreamReset  = goerrors.New("stream reset by peer")
+)
+
+// Stream 在websocket连接上复用的双向字节流, 读取对端的数据帧, 写入时按额度分片发送
+type Stream struct {
+	Id     string
+	conn   *gws.Conn
+	onDone func()
+
+	chunks    chan []byte
+	credit    chan struct{}
+	readEnd   chan struct{}
+	writeEnd  chan struct{}
+	aborted   chan struct{}
+	doneOnce  sync.Once
+	abortOnce sync.Once
+
+	mu          sync.Mutex
+	readClosed  bool
+	writeClosed bool
+	readErr     error
+	writeErr    error
+
+	buf      []byte
+	consumed int
+}
+
+// NewStream 创建流, 读写两端都结束后会调用onDone
+func NewStream(id string, conn *gws.Conn, onDone func()) *Stream {
+	s := &Stream{
+		Id:       id,
+		conn:     conn,
+		onDone:   onDone,
+		chunks:   make(chan []byte, StreamWindow),
+		credit:   make(chan struct{}, StreamWindow),
+		readEnd:  make(chan struct{}),
+		writeEnd: make(chan struct{}),
+		aborted:  make(chan struct{}),
+	}
+	for i := 0; i < StreamWindow; i++ {
+		s.credit <- struct{}{}
+	}
+	return s
+}
+
+func (s *Stream) Conn() *gws.Conn {
+	return s.conn
+}
+
+// Read 读取对端发送的数据, 对端正常结束时返回io.EOF
+func (s *Stream) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		select {
+		case chunk := <-s.chunks:
+			s.take(chunk)
+		case <-s.readEnd:
+			select {
+			case chunk := <-s.chunks:
+				s.take(chunk)
+			default:
+				return 0, s.readErr
+			}
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *Stream) take(chunk []byte) {
+	s.buf = chunk
+	s.consumed++
+	if s.consumed < StreamWindow/2 {
+		return
+	}
+	s.mu.Lock()
+	closed := s.readClosed
+	s.mu.Unlock()
+	if !closed {
+		_ = WriteFrame(s.conn, &Frame{Type: FrameTypeWindow, StreamId: s.Id, Credit: s.consumed})
+	}
+	s.consumed = 0
+}
+
+// Write 分片发送数据, 额度用尽时阻塞直到对端归还额度
+func (s *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		select {
+		case <-s.writeEnd:
+			return written, s.writeErr
+		default:
+		}
+		select {
+		case <-s.credit:
+		case <-s.writeEnd:
+			return written, s.writeErr
+		}
+		size := min(len(p), StreamChunkSize)
+		e := WriteFrame(s.conn, &Frame{Type: FrameTypeData, StreamId: s.Id, Data: p[:size]})
+		if e != nil {
+			s.Abort(e)
+			return written, e
+		}
+		written += size
+		p = p[size:]
+	}
+	return written, nil
+}
+
+// CloseWrite 通知对端数据已发送完毕
+func (s *Stream) CloseWrite() error {
+	return s.CloseWriteWithError(nil)
+}
+
+// CloseWriteWithError 通知对端数据发送异常结束, err为nil时等同于CloseWrite
+func (s *Stream) CloseWriteWithError(err error) error {
+	if !s.finishWrite(ErrStreamClosed) {
+		return nil
+	}
+	frame := &Frame{Type: FrameTypeEnd, StreamId: s.Id}
+	if err != nil {
+		frame.Error = err.Error()
+	}
+	return WriteFrame(s.conn, frame)
+}
+
+// Close 关闭流, 若仍有未结束的方向则通知对端重置
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	finished := s.readClosed && s.writeClosed
+	s.mu.Unlock()
+	if finished {
+		return nil
+	}
+	return s.Reset(ErrStreamClosed)
+}
+
+// Reset 通知对端重置并在本地终止读写
+func (s *Stream) Reset(err error) error {
+	s.Abort(err)
+	return WriteFrame(s.conn, &Frame{Type: FrameTypeReset, StreamId: s.Id, Error: err.Error()})
+}
+
+// Abort 仅在本地终止读写, 用于连接已断开等无需通知对端的场景
+func (s *Stream) Abort(err error) {
+	s.abortOnce.Do(func() {
+		close(s.aborted)
+	})
+	s.finishRead(err)
+	s.finishWrite(err)
+}
+
+// Aborted 流被重置或终止时关闭, 正常结束时不会关闭
+func (s *Stream) Aborted() <-chan struct{} {
+	return s.aborted
+}
+
+// HandleFrame 处理对端发来的数据/结束/流控/重置帧, 其他类型返回false
+func (s *Stream) HandleFrame(frame *Frame) bool {
+	switch frame.Type {
+	case FrameTypeData:
+		select {
+		case s.chunks <- frame.Data:
+		default:
+			// 对端未遵守流控额度
+			_ = s.Reset(goerrors.New("stream window exceeded"))
+		}
+	case FrameTypeEnd:
+		if frame.Error != "" {
+			s.finishRead(goerrors.New(frame.Error))
+		} else {
+			s.finishRead(io.EOF)
+		}
+	case FrameTypeWindow:
+		for i := 0; i < frame.Credit; i++ {
+			select {
+			case s.credit <- struct{}{}:
+			default:
+			}
+		}
+	case FrameTypeReset:
+		e := ErrStreamReset
+		if frame.Error != "" {
+			e = goerrors.Join(ErrStreamReset, goerrors.New(frame.Error))
+		}
+		s.Abort(e)
+	default:
+		return false
+	}
+	return true
+}
+
+func (s *Stream) finishRead(err error) bool {
+	s.mu.Lock()
+	if s.readClosed {
+		s.mu.Unlock()
+		return false
+	}
+	s.readClosed = true
+	s.readErr = err
+	close(s.readEnd)
+	done := s.writeClosed
+	s.mu.Unlock()
+	if done {
+		s.done()
+	}
+	return true
+}
+
+func (s *Stream) finishWrite(err error) bool {
+	s.mu.Lock()
+	if s.writeClosed {
+		s.mu.Unlock()
+		return false
+	}
+	s.writeClosed = true
+	s.writeErr = err
+	close(s.writeEnd)
+	done := s.readClosed
+	s.mu.Unlock()
+	if done {
+		s.done()
+	}
+	return true
+}
+
+func (s *Stream) done() {
+	s.doneOnce.Do(func() {
+		if s.onDone != nil {
+			s.onDone()
+		}
+	})
+}
+
+// CopyAndClose 将reader的数据写入流并结束写入方向
+func CopyAndClose(s *Stream, reader io.Reader) error {
+	buf := make([]byte, StreamChunkSize)
+	_, e := io.CopyBuffer(s, reader, buf)
+	if e != nil {
+		_ = s.CloseWriteWithError(e)
+		return e
+	}
+	return s.CloseWrite()
+}
+
+// Relay 在本地连接和流之间双向转发字节, 直到两个方向都结束.
+// writer支持CloseWrite时对端结束会半关闭本地连接, 异常时会关闭本地连接
+func Relay(reader io.Reader, writer io.Writer, s *Stream) {
+	defer s.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, e := io.Copy(writer, s)
+		if e != nil {
+			if closer, ok := writer.(io.Closer); ok {
+				_ = closer.Close()
+			}
+			return
+		}
+		if closer, ok := writer.(interface{ CloseWrite() error }); ok {
+			_ = closer.CloseWrite()
+		}
+	}()
+	if e := CopyAndClose(s, reader); e != nil {
+		_ = s.Reset(e)
+	}
+	<-done
+}
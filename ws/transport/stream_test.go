@@ -0,0 +1,214 @@
+package transport
+
+import (
+	"bytes"
+	goerrors "errors"
+	"github.com/lxzan/gws"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// frameRouter 把收到的帧交给同一连接上对应的流
+type frameRouter struct {
+	gws.BuiltinEventHandler
+	streams sync.Map
+}
+
+func (r *frameRouter) OnMessage(socket *gws.Conn, message *gws.Message) {
+	defer message.Close()
+	frame, e := ReadFrame(message)
+	if e != nil {
+		return
+	}
+	if value, ok := r.streams.Load(frame.StreamId); ok {
+		value.(*Stream).HandleFrame(frame)
+	}
+}
+
+// streamPair 通过真实的websocket连接创建同一个流的两端
+type streamPair struct {
+	local, remote         *Stream
+	localDone, remoteDone atomic.Int32
+}
+
+func newStreamPair(t *testing.T) *streamPair {
+	t.Helper()
+	serverRouter, clientRouter := &frameRouter{}, &frameRouter{}
+	serverConns := make(chan *gws.Conn, 1)
+	upgrader := gws.NewUpgrader(serverRouter, nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, e := upgrader.Upgrade(w, r)
+		if e != nil {
+			return
+		}
+		serverConns <- conn
+		conn.ReadLoop()
+	}))
+	t.Cleanup(server.Close)
+
+	clientConn, _, e := gws.NewClient(clientRouter, &gws.ClientOption{Addr: "ws://" + strings.TrimPrefix(server.URL, "http://")})
+	if e != nil {
+		t.Fatal(e)
+	}
+	go clientConn.ReadLoop()
+	serverConn := <-serverConns
+	t.Cleanup(func() {
+		_ = clientConn.NetConn().Close()
+	})
+
+	pair := &streamPair{}
+	pair.local = NewStream("s1", clientConn, func() { pair.localDone.Add(1) })
+	pair.remote = NewStream("s1", serverConn, func() { pair.remoteDone.Add(1) })
+	clientRouter.streams.Store("s1", pair.local)
+	serverRouter.streams.Store("s1", pair.remote)
+	return pair
+}
+
+func waitClosed(t *testing.T, ch <-chan struct{}, name string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s not closed", name)
+	}
+}
+
+func TestStreamCopy(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"one byte", 1},
+		{"one chunk", StreamChunkSize},
+		{"chunk boundary", StreamChunkSize + 1},
+		// 超过初始额度, 需要接收方归还额度才能写完
+		{"beyond window", StreamWindow*StreamChunkSize*2 + 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pair := newStreamPair(t)
+			data := bytes.Repeat([]byte("0123456789abcdef"), tt.size/16+1)[:tt.size]
+			errs := make(chan error, 1)
+			go func() {
+				errs <- CopyAndClose(pair.local, bytes.NewReader(data))
+			}()
+			got, e := io.ReadAll(pair.remote)
+			if e != nil {
+				t.Fatalf("read: %v", e)
+			}
+			if e := <-errs; e != nil {
+				t.Fatalf("write: %v", e)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("got %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestStreamWriteBlocksWithoutCredit(t *testing.T) {
+	pair := newStreamPair(t)
+	written := make(chan int, 1)
+	go func() {
+		n, _ := pair.local.Write(make([]byte, (StreamWindow+1)*StreamChunkSize))
+		written <- n
+	}()
+	select {
+	case n := <-written:
+		t.Fatalf("write of %d bytes finished without credit", n)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// 读取一半窗口后对端归还额度, 写入继续
+	buf := make([]byte, StreamChunkSize)
+	for i := 0; i < StreamWindow/2; i++ {
+		if _, e := io.ReadFull(pair.remote, buf); e != nil {
+			t.Fatal(e)
+		}
+	}
+	select {
+	case n := <-written:
+		if n != (StreamWindow+1)*StreamChunkSize {
+			t.Fatalf("written %d", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("write still blocked after credit returned")
+	}
+}
+
+func TestStreamWindowExceeded(t *testing.T) {
+	pair := newStreamPair(t)
+	// 不按额度发送的对端会被重置
+	for i := 0; i <= StreamWindow; i++ {
+		pair.remote.HandleFrame(&Frame{Type: FrameTypeData, StreamId: "s1", Data: []byte("x")})
+	}
+	waitClosed(t, pair.remote.Aborted(), "remote")
+	waitClosed(t, pair.local.Aborted(), "local")
+	if _, e := pair.local.Write([]byte("x")); !goerrors.Is(e, ErrStreamReset) {
+		t.Fatalf("write after reset: %v", e)
+	}
+}
+
+func TestStreamReset(t *testing.T) {
+	pair := newStreamPair(t)
+	cause := goerrors.New("cancelled")
+	if e := pair.local.Reset(cause); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := pair.local.Read(make([]byte, 1)); e != cause {
+		t.Fatalf("local read: %v", e)
+	}
+	if _, e := pair.local.Write([]byte("x")); e != cause {
+		t.Fatalf("local write: %v", e)
+	}
+
+	waitClosed(t, pair.remote.Aborted(), "remote")
+	_, e := pair.remote.Read(make([]byte, 1))
+	if !goerrors.Is(e, ErrStreamReset) || !strings.Contains(e.Error(), "cancelled") {
+		t.Fatalf("remote read: %v", e)
+	}
+	if pair.localDone.Load() != 1 || pair.remoteDone.Load() != 1 {
+		t.Fatalf("onDone called %d/%d times", pair.localDone.Load(), pair.remoteDone.Load())
+	}
+}
+
+func TestStreamCloseAfterEnd(t *testing.T) {
+	pair := newStreamPair(t)
+	if e := pair.local.CloseWrite(); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := pair.local.Write([]byte("x")); e != ErrStreamClosed {
+		t.Fatalf("write after CloseWrite: %v", e)
+	}
+	if _, e := pair.remote.Read(make([]byte, 1)); e != io.EOF {
+		t.Fatalf("remote read: %v", e)
+	}
+	if e := pair.remote.CloseWrite(); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := pair.local.Read(make([]byte, 1)); e != io.EOF {
+		t.Fatalf("local read: %v", e)
+	}
+
+	// 两个方向都已正常结束, Close不再通知对端重置
+	_ = pair.local.Close()
+	_ = pair.remote.Close()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-pair.local.Aborted():
+		t.Fatal("local aborted after normal end")
+	case <-pair.remote.Aborted():
+		t.Fatal("remote aborted after normal end")
+	default:
+	}
+	if pair.localDone.Load() != 1 || pair.remoteDone.Load() != 1 {
+		t.Fatalf("onDone called %d/%d times", pair.localDone.Load(), pair.remoteDone.Load())
+	}
+}
@@ -0,0 +1,9 @@
+package transport
+
+// TunnelOpenRequest 打开隧道的请求, 边缘节点连接目标地址后原样转发字节
+type TunnelOpenRequest struct {
+	Network string  `msgpack:"network"`
+	Address string  `msgpack:"address"`
+	Timeout float64 `msgpack:"timeout"`
+	EdgeId  string  `msgpack:"edgeId"`
+}
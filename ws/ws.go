@@ -1,12 +1,16 @@
 package ws
 
 import (
+	"asyncProxy/constant"
 	"asyncProxy/ws/edge"
 	"asyncProxy/ws/transport"
+	"context"
 	"fmt"
 	"github.com/lxzan/gws"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -16,9 +20,14 @@ type Handler struct {
 }
 
 func (c *Handler) OnOpen(socket *gws.Conn) {
-	edgeId := EdgeSet.Add(socket)
+	var info edge.EdgeInfo
+	if value, ok := socket.Session().Load(constant.ConnSessionEdgeInfo); ok {
+		info, _ = value.(edge.EdgeInfo)
+	}
+	edgeId := EdgeSet.Add(socket, info)
 	log.Println("连接建立, 分配的节点ID为:", edgeId)
 	log.Println("当前在线节点数为:", EdgeSet.Len())
+	go ping(socket)
 }
 
 func (c *Handler) OnClose(socket *gws.Conn, err error) {
@@ -38,10 +47,39 @@ func (c *Handler) OnPing(socket *gws.Conn, payload []byte) {
 	}
 }
 
-func (c *Handler) OnPong(_ *gws.Conn, _ []byte) {}
+func (c *Handler) OnPong(socket *gws.Conn, payload []byte) {
+	EdgeSet.OnPong(socket, payload)
+}
+
+// ping 定时发送携带发送时间的心跳, 用于测量节点RTT
+func ping(socket *gws.Conn) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		if e := socket.WritePing([]byte(strconv.FormatInt(time.Now().UnixNano(), 10))); e != nil {
+			return
+		}
+		<-ticker.C
+	}
+}
+
+// parseEdgeInfo 解析边缘节点握手请求头中声明的信息
+func parseEdgeInfo(request *http.Request) edge.EdgeInfo {
+	info := edge.EdgeInfo{Weight: 1}
+	if weight, e := strconv.Atoi(request.Header.Get(constant.HeaderEdgeWeight)); e == nil && weight > 0 {
+		info.Weight = weight
+	}
+	if maxInFlight, e := strconv.Atoi(request.Header.Get(constant.HeaderEdgeMaxInFlight)); e == nil && maxInFlight > 0 {
+		info.MaxInFlight = maxInFlight
+	}
+	if labels := request.Header.Get(constant.HeaderEdgeLabels); labels != "" {
+		info.Labels = transport.ParseLabels(labels)
+	}
+	return info
+}
 
 func (c *Handler) OnMessage(socket *gws.Conn, message *gws.Message) {
-	_, e := EdgeSet.OnResponse(socket, message)
+	e := EdgeSet.OnMessage(socket, message)
 	if e != nil {
 		log.Println("websocket消息处理失败:", e)
 	}
@@ -50,7 +88,8 @@ func (c *Handler) OnMessage(socket *gws.Conn, message *gws.Message) {
 func Start(host string, port uint16, authorization string) {
 	var handler *Handler = &Handler{}
 	upgrader := gws.NewUpgrader(handler, &gws.ServerOption{
-		ReadAsyncEnabled: true,
+		// 同一个流的消息帧必须按顺序处理, 不能开启并行读
+		ReadAsyncEnabled: false,
 		CompressEnabled:  true,
 		Recovery:         gws.Recovery,
 	})
@@ -66,6 +105,7 @@ func Start(host string, port uint16, authorization string) {
 			_, _ = writer.Write([]byte("websocket connect error"))
 			return
 		}
+		socket.Session().Store(constant.ConnSessionEdgeInfo, parseEdgeInfo(request))
 		go func() {
 			socket.ReadLoop()
 		}()
@@ -89,3 +129,32 @@ func SendRequestAndWait(method, url string, headers map[string][]string, body []
 	response, e := EdgeSet.DispatchRequestAndWait(method, url, headers, body, timeout)
 	return response, e
 }
+
+// SendRequestAsync 发送请求, 回调在收到响应头时触发, 响应体通过response.BodyReader读取
+func SendRequestAsync(request *edge.Request, callback edge.OnResponseCompleteCallback) error {
+	_, e := EdgeSet.DispatchRequestAsync(request, callback)
+	return e
+}
+
+// SendRequestContext 发送请求然后等待请求结果, ctx结束时取消请求
+func SendRequestContext(ctx context.Context, request *edge.Request) (*transport.WebsocketProxyResponse, error) {
+	return EdgeSet.DispatchRequestContext(ctx, request)
+}
+
+// SendRequestWithRetry 发送请求然后等待请求结果, 失败时按策略换节点重试
+func SendRequestWithRetry(ctx context.Context, method, url string, headers map[string][]string, body io.Reader,
+	policy *edge.RetryPolicy, options *edge.DispatchOptions) (*transport.WebsocketProxyResponse, []edge.Attempt, error) {
+	return EdgeSet.DispatchRequestWithRetry(ctx, method, url, headers, body, policy, options)
+}
+
+// OpenTunnel 通过边缘节点打开原始TCP隧道
+func OpenTunnel(network, address string, timeout time.Duration, options *edge.DispatchOptions) (*transport.Stream, error) {
+	stream, _, e := EdgeSet.OpenTunnel(network, address, timeout, options)
+	return stream, e
+}
+
+// OpenAssociation 通过边缘节点打开UDP关联
+func OpenAssociation(idleTimeout time.Duration, options *edge.DispatchOptions) (*transport.Association, error) {
+	association, _, e := EdgeSet.OpenAssociation(idleTimeout, options)
+	return association, e
+}